@@ -1,26 +1,44 @@
 package main
 
 import (
+	"errors"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
 
 	"github.com/docopt/docopt-go"
 	"github.com/kovetskiy/lorg"
 
 	"github.com/reconquest/zeus/pkg/backup"
+	"github.com/reconquest/zeus/pkg/backup/errs"
 	"github.com/reconquest/zeus/pkg/config"
 	"github.com/reconquest/zeus/pkg/exec"
 	"github.com/reconquest/zeus/pkg/log"
+	"github.com/reconquest/zeus/pkg/zfs"
 )
 
 var version = "[manual build]"
 
+// exitCodeDatasetsFailed is used when backup of some datasets failed, but
+// remaining datasets were backed up.
+const exitCodeDatasetsFailed = 2
+
+// exitCodeStatusCritical is used when status check has found problems, it
+// matches CRITICAL state of Nagios-compatible checks.
+const exitCodeStatusCritical = 2
+
 var usage = `zeus - zfs backup tool.
 
 Usage:
   zeus -h | --help
-  zeus [options] backup [--no-export]
+  zeus [options] backup [--no-export] [--force] [--jobs=<n>] [--fail-fast]
+                        [--dry-run]
+  zeus [options] restore [--no-export] [--force] [--snapshot=<name>]
+                         [--dry-run] <source> <target>
+  zeus [options] list [--no-export] [--format=<format>]
+  zeus [options] status [--no-export] [--format=<format>] [--max-age=<age>]
+  zeus [options] receive --compression=<algorithm> [--] <args>...
 
 Options:
   -h --help           Show this help.
@@ -28,16 +46,47 @@ Options:
                        [default: $CONFIG]
   --no-export         Will not export target pool at the end of backup
                        operation.
+  -j --jobs=<n>       Amount of datasets to backup concurrently, overrides
+                       'concurrency' config option.
+  --fail-fast         Stop backup as soon as backup of any dataset fails.
+  --force             Backup datasets regardless of their backup interval or
+                       overwrite restore target if it already exists.
+  --snapshot=<name>   Restore specified snapshot instead of latest one.
+  --dry-run           Do not make any changes, print zfs commands which would
+                       be executed instead.
+  --format=<format>   Output format for list and status, either 'text' or
+                       'json'. [default: text]
+  --max-age=<age>     Report backups older than specified age as stale,
+                       overrides 'status.max_age' config option.
+  --compression=<algorithm>
+                      Decompress stream read from stdin using specified
+                       algorithm and pass it to zfs recv with given
+                       arguments, used on remote host receiving backup.
   --debug             Output debug messages in logs.
   --trace             Output trace messages in logs.
 `
 
 type Opts struct {
-	ValueConfig  string `docopt:"--config"`
-	ModeBackup   bool   `docopt:"backup"`
-	FlagNoExport bool   `docopt:"--no-export"`
-	FlagDebug    bool   `docopt:"--debug"`
-	FlagTrace    bool   `docopt:"--trace"`
+	ValueConfig      string   `docopt:"--config"`
+	ModeBackup       bool     `docopt:"backup"`
+	ModeRestore      bool     `docopt:"restore"`
+	ModeList         bool     `docopt:"list"`
+	ModeStatus       bool     `docopt:"status"`
+	ModeReceive      bool     `docopt:"receive"`
+	ArgSource        string   `docopt:"<source>"`
+	ArgTarget        string   `docopt:"<target>"`
+	ArgsReceive      []string `docopt:"<args>"`
+	ValueSnapshot    string   `docopt:"--snapshot"`
+	ValueFormat      string   `docopt:"--format"`
+	ValueJobs        string   `docopt:"--jobs"`
+	ValueMaxAge      string   `docopt:"--max-age"`
+	ValueCompression string   `docopt:"--compression"`
+	FlagNoExport     bool     `docopt:"--no-export"`
+	FlagForce        bool     `docopt:"--force"`
+	FlagFailFast     bool     `docopt:"--fail-fast"`
+	FlagDryRun       bool     `docopt:"--dry-run"`
+	FlagDebug        bool     `docopt:"--debug"`
+	FlagTrace        bool     `docopt:"--trace"`
 }
 
 func init() {
@@ -86,21 +135,92 @@ func main() {
 	}
 
 	exec.SetLogger(log.NewChildWithPrefix("{exec}"))
+	exec.SetDryRun(opts.FlagDryRun)
+
+	// receive mode is run by zeus on remote host, it doesn't need config
+	if opts.ModeReceive {
+		err = receive(opts.ValueCompression, opts.ArgsReceive)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
 
 	config, err := config.LoadConfig(opts.ValueConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	var jobs int
+
+	if opts.ValueJobs != "" {
+		jobs, err = strconv.Atoi(opts.ValueJobs)
+		if err != nil || jobs < 1 {
+			log.Fatalf("invalid --jobs value: %q", opts.ValueJobs)
+		}
+	}
+
 	switch {
 	case opts.ModeBackup:
 		err = backup.Backup(
 			config,
 			backup.OptNoExport(opts.FlagNoExport),
+			backup.OptForce(opts.FlagForce),
+			backup.OptConcurrency(jobs),
+			backup.OptFailFast(opts.FlagFailFast),
+		)
+
+	case opts.ModeRestore:
+		err = backup.Restore(
+			config,
+			opts.ArgSource,
+			opts.ArgTarget,
+			backup.OptNoExport(opts.FlagNoExport),
+			backup.OptForce(opts.FlagForce),
+			backup.OptSnapshot(opts.ValueSnapshot),
+		)
+
+	case opts.ModeList:
+		err = backup.List(
+			config,
+			backup.OptNoExport(opts.FlagNoExport),
+			backup.OptFormat(opts.ValueFormat),
+		)
+
+	case opts.ModeStatus:
+		err = backup.Status(
+			config,
+			backup.OptNoExport(opts.FlagNoExport),
+			backup.OptFormat(opts.ValueFormat),
+			backup.OptMaxAge(opts.ValueMaxAge),
 		)
 	}
 
 	if err != nil {
+		var failed errs.DatasetsFailed
+		if errors.As(err, &failed) {
+			log.Error(err)
+			os.Exit(exitCodeDatasetsFailed)
+		}
+
+		var critical errs.StatusCritical
+		if errors.As(err, &critical) {
+			log.Error(err)
+			os.Exit(exitCodeStatusCritical)
+		}
+
 		log.Fatal(err)
 	}
 }
+
+// receive decompresses stream sent by zeus from another host and passes it
+// to zfs recv.
+func receive(algorithm string, args []string) error {
+	compression, err := zfs.ParseCompression(algorithm)
+	if err != nil {
+		return err
+	}
+
+	return zfs.Local.ReceiveCompressed(os.Stdin, compression, args...)
+}
@@ -3,7 +3,9 @@ package backup
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/reconquest/zeus/pkg/backup/errs"
@@ -11,6 +13,7 @@ import (
 	"github.com/reconquest/zeus/pkg/backup/operation"
 	"github.com/reconquest/zeus/pkg/config"
 	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/duration"
 	"github.com/reconquest/zeus/pkg/exec"
 	pkg_log "github.com/reconquest/zeus/pkg/log"
 	"github.com/reconquest/zeus/pkg/text"
@@ -28,77 +31,170 @@ type (
 		operation.Backup
 		housekeeping.Policy
 	}
+
+	// latestSnapshot is the newest snapshot made by zeus in target dataset.
+	latestSnapshot struct {
+		Name      string
+		GUID      string
+		CreateTXG uint64
+	}
 )
 
 type (
 	Opts interface{}
 
-	OptNoExport bool
+	OptNoExport    bool
+	OptConcurrency int
+	OptFailFast    bool
+
+	// OptBackend overrides backends used to access source datasets and
+	// target backup pool.
+	OptBackend struct {
+		Source zfs.Backend
+		Target zfs.Backend
+	}
+
+	// OptNow overrides clock which is used to name snapshots and to check
+	// whether backup is due.
+	OptNow func() time.Time
 )
 
+// Backup makes backup to the configured target pool. If multiple targets
+// are configured, backup is made to the available ones, each target keeps its
+// own incremental base and holds.
 func Backup(config *config.Config, opts ...Opts) error {
-	var noExport bool
+	var (
+		backend OptBackend
+		clock   = time.Now
+	)
+
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case OptBackend:
+			backend = opt
+		case OptNow:
+			clock = opt
+		}
+	}
+
+	if exec.IsDryRun() {
+		log.Warningf("dry run mode enabled, no changes will be made")
+
+		defer printPlan()
+	}
+
+	_, host := getBackends(config, backend)
+
+	targets, err := selectTargets(config, host)
+	if err != nil {
+		return err
+	}
+
+	var failed error
+
+	// the same snapshot is sent to every target
+	now := clock().UTC()
+
+	for _, target := range targets {
+		err := backupTarget(config.WithTarget(target), now, opts...)
+		if err != nil {
+			if len(targets) > 1 {
+				log.Errorf(
+					"backup to target backup pool %q failed: %s",
+					target.Pool,
+					err,
+				)
+			}
+
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+
+	return failed
+}
+
+func backupTarget(
+	config *config.Config,
+	now time.Time,
+	opts ...Opts,
+) error {
+	var (
+		noExport    bool
+		force       bool
+		concurrency = config.Concurrency
+		failFast    = config.FailFast
+		backend     OptBackend
+	)
 
 	for _, opt := range opts {
 		switch opt := opt.(type) {
 		case OptNoExport:
 			noExport = bool(opt)
+		case OptForce:
+			force = bool(opt)
+		case OptConcurrency:
+			if opt > 0 {
+				concurrency = int(opt)
+			}
+		case OptFailFast:
+			if opt {
+				failFast = true
+			}
+		case OptBackend:
+			backend = opt
 		}
 	}
 
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// commands of different datasets would be interleaved in the plan
+	// otherwise
+	if exec.IsDryRun() {
+		concurrency = 1
+	}
+
 	targetDatasetName := fmt.Sprintf(
 		"%s/%s",
 		config.TargetPool,
 		config.TargetDataset,
 	)
 
+	local, target := getBackends(config, backend)
+
+	log.Infof("target backup host: %q", target)
 	log.Infof("target backup pool: %q", config.TargetPool)
 	log.Infof("target backup dataset: %q", targetDatasetName)
 
-	log.Debugf("checking that target backup pool is imported")
-
-	if ok, err := isPoolImported(config.TargetPool); !ok {
+	if isPoolManaged(config, target) {
+		_, err := importPool(target, config.TargetPool)
 		if err != nil {
 			return err
 		}
 
-		log.Infof(
-			"target backup pool %q is not imported, importing pool",
-			config.TargetPool,
-		)
-
-		err := zfs.ImportPool(config.TargetPool)
-		if err != nil {
-			return karma.Format(
-				err,
-				"unable to import pool",
-			)
+		if !noExport {
+			defer exportPool(target, config.TargetPool)
 		}
 	} else {
-		log.Infof(
-			"target backup %q pool is already imported",
-			config.TargetPool,
-		)
-	}
-
-	if !noExport {
-		defer func() {
-			log.Infof("exporting target backup pool %q", config.TargetPool)
-			err := zfs.ExportPool(config.TargetPool)
-			if err != nil {
-				log.Errorf(karma.Format(
-					err,
-					"unable to export pool",
-				).String())
-			}
-		}()
+		err := requirePoolImported(target, config.TargetPool)
+		if err != nil {
+			return err
+		}
 	}
 
 	// TODO(seletskiy): check S.M.A.R.T. before attempting backup
 
+	poolGUID, err := getPoolGUID(target, config.TargetPool)
+	if err != nil {
+		return err
+	}
+
 	log.Debugf("retrieving datasets to backup")
 
-	operations, err := getBackupOperations(config)
+	operations, err := getBackupOperations(config, local)
 	if err != nil {
 		return karma.Format(
 			err,
@@ -122,20 +218,37 @@ func Backup(config *config.Config, opts ...Opts) error {
 		return nil
 	}
 
-	log.Debugf("checking dataset %q encryption status", targetDatasetName)
-
-	encrypted, encryptionRoot, err := loadEncryptionKey(
-		config,
-		config.TargetPool,
+	var (
+		encrypted      bool
+		encryptionRoot string
 	)
-	if err != nil {
-		return karma.Format(
-			err,
-			"unable to load encryption key",
+
+	// raw streams are received encrypted with the source key, so target
+	// key is not needed if all datasets are sent raw
+	if isRawOnly(operations) {
+		log.Infof(
+			"all datasets are sent raw, skipping loading encryption key",
+		)
+	} else {
+		log.Debugf(
+			"checking dataset %q encryption status",
+			targetDatasetName,
+		)
+
+		encrypted, encryptionRoot, err = loadEncryptionKey(
+			config,
+			target,
+			config.TargetPool,
 		)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to load encryption key",
+			)
+		}
 	}
 
-	err = zfs.EnsureDatasetExists(targetDatasetName)
+	err = target.EnsureDatasetExists(targetDatasetName)
 	if err != nil {
 		return karma.Format(
 			err,
@@ -146,7 +259,7 @@ func Backup(config *config.Config, opts ...Opts) error {
 	if encrypted {
 		defer func() {
 			log.Infof("unloading encryption key from %q", encryptionRoot)
-			err := zfs.UnloadKey(encryptionRoot)
+			err := target.UnloadKey(encryptionRoot)
 			if err != nil {
 				log.Error(karma.Format(
 					err,
@@ -160,6 +273,7 @@ func Backup(config *config.Config, opts ...Opts) error {
 	log.Debugf("listing snapshots on the backup dataset %q", targetDatasetName)
 
 	targetSnapshotsBySource, err := getLatestTargetSnapshotsBySource(
+		target,
 		targetDatasetName,
 	)
 	if err != nil {
@@ -169,122 +283,272 @@ func Backup(config *config.Config, opts ...Opts) error {
 		)
 	}
 
-	var backuped int
+	targetDatasets, err := listTargetDatasets(target, targetDatasetName)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to list datasets in backup dataset",
+		)
+	}
 
-	currentSnapshot := config.SnapshotPrefix +
-		time.Now().UTC().Format(time.RFC3339)
+	var (
+		workers = make(chan struct{}, concurrency)
+		group   sync.WaitGroup
+		mutex   sync.Mutex
+		failed  error
+		results = make([]backupResult, len(operations))
+		queue   = []int{}
+	)
 
-	for _, operation := range operations {
+	currentSnapshot := config.SnapshotPrefix + now.Format(time.RFC3339)
+
+	log.Infof(
+		"found %d %s to backup, running with concurrency %d",
+		len(operations),
+		text.Pluralize("dataset", len(operations)),
+		concurrency,
+	)
+
+	for i, operation := range operations {
 		var (
-			namespace = "guid:" + operation.GUID[len(operation.GUID)-7:]
-			source    = fmt.Sprintf("%s/%s", namespace, operation.Source)
+			namespace = getCurrentNamespace(
+				operation.GUID,
+				operation.Source,
+				targetDatasets,
+			)
+			source = fmt.Sprintf("%s/%s", namespace, operation.Source)
 		)
 
+		results[i] = backupResult{
+			Source: operation.Source,
+			Status: backupStatusSkipped,
+			Reason: "not started because backup of another dataset failed",
+		}
+
 		operation.Target = fmt.Sprintf("%s/%s", targetDatasetName, namespace)
+		operation.TargetPoolGUID = poolGUID
+		operation.NewChainTarget = fmt.Sprintf(
+			"%s/%s",
+			targetDatasetName,
+			getNewChainNamespace(operation.GUID, now),
+		)
 
 		operation.Snapshot.Current = currentSnapshot
-		operation.Snapshot.Base = targetSnapshotsBySource[source]
+		operation.Snapshot.Base = targetSnapshotsBySource[source].Name
+		operation.Snapshot.BaseGUID = targetSnapshotsBySource[source].GUID
+
+		operation.Hosts.Source = local
+		operation.Hosts.Target = target
+
+		operations[i] = operation
+
+		if !force {
+			due, reason := isBackupDue(config, operation.Backup, now)
+			if !due {
+				results[i].Reason = reason
+
+				continue
+			}
+		}
 
-		err := operation.Run()
+		queue = append(queue, i)
+	}
+
+	if config.IsSpaceChecked() {
+		queue, err = checkCapacity(
+			target,
+			config.TargetPool,
+			config.HoldTag,
+			config.OnInsufficientSpace,
+			operations,
+			queue,
+			results,
+			failFast,
+		)
 		if err != nil {
 			return err
 		}
+	}
 
-		log.Infof(
-			"dataset copy %q -> %q completed successfully",
-			fmt.Sprintf("%s@%s", operation.Source, operation.Snapshot.Current),
-			operation.Target,
-		)
+	for n, i := range queue {
+		operation := operations[i]
 
-		err = housekeeping.ApplyHolds(config.HoldTag, operation.Backup)
-		if err != nil {
-			return karma.Format(
-				err,
-				"unable to apply holds",
-			)
+		workers <- struct{}{}
+
+		mutex.Lock()
+		stop := failed != nil && failFast
+		mutex.Unlock()
+
+		if stop {
+			<-workers
+
+			// snapshots made to estimate operations which are not
+			// started are not needed anymore
+			discardSnapshots(operations, queue[n:], nil)
+
+			break
 		}
 
-		log.Infof(
-			"applying houseskeeping policy %q | source %q | target %q",
-			operation.Policy.GetName(),
-			operation.Source,
-			operation.Target,
-		)
+		group.Add(1)
 
-		err = operation.Policy.Cleanup(operation.Backup)
-		if err != nil {
-			return karma.Format(
-				err,
-				"unable to run housekeeping",
-			)
+		go func(i int, operation BackupOperationWithHousekeeping) {
+			defer func() {
+				<-workers
+				group.Done()
+			}()
+
+			err := runBackupOperation(config, operation)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				operation.NewLog("{backup}").Error(err)
+
+				results[i].Status = backupStatusFailed
+				results[i].Reason = err.Error()
+
+				if failed == nil {
+					failed = err
+				}
+			} else {
+				results[i].Status = backupStatusOK
+				results[i].Reason = ""
+			}
+		}(i, operation)
+	}
+
+	group.Wait()
+
+	summary := summarizeBackupResults(results)
+
+	log.Info(formatBackupResults(results))
+
+	if summary.Failed > 0 {
+		if failFast {
+			return failed
 		}
 
-		backuped++
+		return errs.DatasetsFailed{
+			Failed: summary.Failed,
+			Total:  len(results),
+		}
 	}
 
 	log.Infof(
 		"backup successfully completed for %d %s",
-		backuped,
-		text.Pluralize("dataset", backuped),
+		summary.OK,
+		text.Pluralize("dataset", summary.OK),
 	)
 
 	return nil
 }
 
-func isPoolImported(name string) (bool, error) {
-	if ok, err := isPoolInImportList(name); ok {
-		return true, nil
-	} else {
-		if err != nil {
-			return false, karma.Format(
-				err,
-				"unable to check that pool is in import list",
-			)
+// isRawOnly reports whether all given operations use raw send.
+func isRawOnly(operations []BackupOperationWithHousekeeping) bool {
+	for _, operation := range operations {
+		if !operation.Raw {
+			return false
 		}
+	}
 
-		if ok, err := isPoolInImportedList(name); ok {
-			return true, nil
-		} else {
-			if err != nil {
-				return false, karma.Format(
-					err,
-					"unable to check that pool is in imported list",
-				)
-			}
+	return true
+}
 
-			return false, nil
-		}
+func runBackupOperation(
+	config *config.Config,
+	operation BackupOperationWithHousekeeping,
+) error {
+	log := operation.NewLog("{backup}")
+
+	err := operation.Run()
+	if err != nil {
+		return err
 	}
-}
 
-func isPoolInImportList(name string) (bool, error) {
-	pools, err := zfs.GetImportList()
+	log.Infof(
+		"dataset copy %q -> %q completed successfully",
+		fmt.Sprintf("%s@%s", operation.Source, operation.Snapshot.Current),
+		operation.Target,
+	)
+
+	err = housekeeping.ApplyHolds(config.HoldTag, operation.Backup)
 	if err != nil {
-		return false, err
+		return karma.Format(
+			err,
+			"unable to apply holds",
+		)
 	}
 
-	for _, pool := range pools {
-		if pool == name {
-			return true, nil
-		}
+	log.Infof(
+		"applying houseskeeping policy %q | source %q | target %q",
+		operation.Policy.GetName(),
+		operation.Source,
+		operation.Target,
+	)
+
+	err = operation.Policy.Cleanup(operation.Backup)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to run housekeeping",
+		)
 	}
 
-	return false, nil
+	return nil
 }
 
-func isPoolInImportedList(name string) (bool, error) {
-	importedPools, err := zfs.GetImportedPools()
+// isBackupDue checks that backup interval configured for given operation has
+// passed since the latest snapshot received on the target. If it's not,
+// reason for skipping backup is returned.
+func isBackupDue(
+	config *config.Config,
+	operation operation.Backup,
+	now time.Time,
+) (bool, string) {
+	if operation.Interval == 0 || operation.Snapshot.Base == "" {
+		return true, ""
+	}
+
+	if !strings.HasPrefix(operation.Snapshot.Base, config.SnapshotPrefix) {
+		return true, ""
+	}
+
+	latest, err := time.Parse(
+		time.RFC3339,
+		strings.TrimPrefix(operation.Snapshot.Base, config.SnapshotPrefix),
+	)
 	if err != nil {
-		return false, err
+		log.Warningf(
+			"unable to parse time of latest backup snapshot %q, "+
+				"ignoring backup interval for dataset %q",
+			operation.Snapshot.Base,
+			operation.Source,
+		)
+
+		return true, ""
 	}
 
-	for _, importedPoolName := range importedPools {
-		if importedPoolName == name {
-			return true, nil
-		}
+	passed := now.Sub(latest)
+
+	if passed >= operation.Interval {
+		return true, ""
 	}
 
-	return false, nil
+	log.Infof(
+		"skipping dataset %q because latest backup %q was made %s ago, "+
+			"while backup interval is %s",
+		operation.Source,
+		operation.Snapshot.Base,
+		passed.Truncate(time.Second),
+		operation.Interval,
+	)
+
+	return false, fmt.Sprintf(
+		"backup interval %s has not passed yet, next backup is due in %s",
+		operation.Interval,
+		(operation.Interval - passed).Truncate(time.Second),
+	)
 }
 
 func applyProperty(
@@ -302,6 +566,14 @@ func applyProperty(
 				property.Source,
 			)
 
+		case "recursive":
+			operation.Enabled = true
+			operation.Recursive = true
+			log.Infof(
+				"will backup dataset %q along with its descendants",
+				property.Source,
+			)
+
 		case "off":
 			operation.Enabled = false
 
@@ -310,6 +582,34 @@ func applyProperty(
 				property.Source, constants.Backup,
 			)
 
+		default:
+			return operation, errs.UnsupportedPropertyValue(
+				property,
+				[]string{"on", "recursive", "off"},
+			)
+		}
+
+	case constants.BackupInterval:
+		interval, err := duration.Parse(property.Value)
+		if err != nil {
+			return operation, karma.
+				Describe("dataset", property.Source).
+				Describe("property", property.Name).
+				Describe("value", property.Value).
+				Format(
+					err,
+					"unable to parse backup interval",
+				)
+		}
+
+		operation.Interval = interval
+
+	case constants.BackupRaw:
+		switch property.Value {
+		case "on":
+			operation.Raw = true
+		case "off":
+			operation.Raw = false
 		default:
 			return operation, errs.UnsupportedPropertyValue(
 				property,
@@ -317,8 +617,61 @@ func applyProperty(
 			)
 		}
 
+	case constants.BackupOnBrokenChain:
+		switch property.Value {
+		case "fail", "full":
+			operation.OnBrokenChain = property.Value
+		default:
+			return operation, errs.UnsupportedPropertyValue(
+				property,
+				[]string{"fail", "full"},
+			)
+		}
+
+	case constants.SendFlags:
+		flags, err := zfs.ParseSendFlags(property.Value)
+		if err != nil {
+			return operation, errs.InvalidPropertyValue(property, err)
+		}
+
+		operation.Flags.Send = flags
+
+	case constants.RecvExcludeProperties:
+		flags, err := zfs.ParseRecvExcludeProperties(property.Value)
+		if err != nil {
+			return operation, errs.InvalidPropertyValue(property, err)
+		}
+
+		operation.Flags.RecvExclude = flags
+
+	case constants.RecvSetProperties:
+		flags, err := zfs.ParseRecvSetProperties(property.Value)
+		if err != nil {
+			return operation, errs.InvalidPropertyValue(property, err)
+		}
+
+		operation.Flags.RecvSet = flags
+
+	case constants.BackupRateLimit:
+		rate, err := parseRate(property.Value)
+		if err != nil {
+			return operation, karma.
+				Describe("dataset", property.Source).
+				Describe("property", property.Name).
+				Describe("value", property.Value).
+				Format(
+					err,
+					"unable to parse backup rate limit",
+				)
+		}
+
+		operation.RateLimit.Rate = rate
+
 	case constants.GUID:
 		operation.GUID = property.Value
+
+	case constants.Type:
+		operation.Volume = property.Value == constants.TypeVolume
 	}
 
 	return operation, nil
@@ -326,12 +679,78 @@ func applyProperty(
 
 func getBackupOperations(
 	config *config.Config,
+	source zfs.Backend,
 ) ([]BackupOperationWithHousekeeping, error) {
-	mappings, err := zfs.GetDatasetProperties(
+	mappings, err := source.GetDatasetProperties(
 		append(
 			[]zfs.PropertyRequest{
-				{Name: constants.GUID, System: true, Filesystem: true},
-				{Name: constants.Backup, Local: true, Filesystem: true},
+				{
+					Name:       constants.GUID,
+					System:     true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.Type,
+					System:     true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.Backup,
+					Local:      true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.BackupInterval,
+					Local:      true,
+					Inherited:  true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.BackupRateLimit,
+					Local:      true,
+					Inherited:  true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.BackupRaw,
+					Local:      true,
+					Inherited:  true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.BackupOnBrokenChain,
+					Local:      true,
+					Inherited:  true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.SendFlags,
+					Local:      true,
+					Inherited:  true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.RecvExcludeProperties,
+					Local:      true,
+					Inherited:  true,
+					Filesystem: true,
+					Volume:     true,
+				},
+				{
+					Name:       constants.RecvSetProperties,
+					Local:      true,
+					Inherited:  true,
+					Filesystem: true,
+					Volume:     true,
+				},
 			},
 			housekeeping.Properties...,
 		),
@@ -343,8 +762,35 @@ func getBackupOperations(
 		)
 	}
 
+	rateLimit, err := getRateLimit(config)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferSize, err := getBufferSize(config)
+	if err != nil {
+		return nil, err
+	}
+
+	compression, err := zfs.ParseCompression(config.Remote.Compression)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"invalid remote stream compression",
+		)
+	}
+
+	defaults, err := getDefaultFlags(config)
+	if err != nil {
+		return nil, err
+	}
+
 	var (
 		operations = []BackupOperationWithHousekeeping{}
+
+		// all datasets are sent through the same limiter, so max_rate is
+		// not multiplied by amount of concurrently running backups
+		rateLimiter = zfs.NewRateLimiter(rateLimit)
 	)
 
 mappingsLoop:
@@ -352,6 +798,10 @@ mappingsLoop:
 		var operation BackupOperationWithHousekeeping
 
 		operation.Source = mapping.Source
+		operation.RateLimiter = rateLimiter
+		operation.BufferSize = bufferSize
+		operation.Compression = compression
+		operation.Flags = defaults
 
 		for _, property := range mapping.Properties {
 			operation, err = applyProperty(config, operation, property)
@@ -366,6 +816,11 @@ mappingsLoop:
 			continue
 		}
 
+		// rate windows take precedence over rate limit of the dataset
+		if operation.RateLimit.Rate != 0 {
+			operation.RateLimit.Windows = rateLimit.Windows
+		}
+
 		policy, err := housekeeping.Configure(config, mapping.Properties)
 		if err != nil {
 			return nil, karma.Format(
@@ -379,35 +834,150 @@ mappingsLoop:
 		operations = append(operations, operation)
 	}
 
-	return operations, nil
+	return skipDescendants(operations), nil
 }
 
+// skipDescendants removes operations of datasets which are descendants of
+// datasets backed up recursively, since they are already included into
+// replication stream.
+func skipDescendants(
+	operations []BackupOperationWithHousekeeping,
+) []BackupOperationWithHousekeeping {
+	result := []BackupOperationWithHousekeeping{}
+
+	for _, operation := range operations {
+		var ancestor string
+
+		for _, candidate := range operations {
+			if candidate.Recursive &&
+				strings.HasPrefix(operation.Source, candidate.Source+"/") {
+				ancestor = candidate.Source
+
+				break
+			}
+		}
+
+		if ancestor != "" {
+			log.Warningf(
+				"skipping dataset %q because it's backed up recursively "+
+					"along with %q",
+				operation.Source,
+				ancestor,
+			)
+
+			continue
+		}
+
+		result = append(result, operation)
+	}
+
+	return result
+}
+
+// getLatestTargetSnapshotsBySource returns the newest snapshots made by zeus
+// by their creation txg for every dataset in given target dataset. Snapshots
+// which are not made by zeus are reported and ignored.
 func getLatestTargetSnapshotsBySource(
+	target zfs.Backend,
 	targetDatasetName string,
-) (map[string]string, error) {
-	targetSnapshots, err := zfs.ListSnapshots(targetDatasetName)
+) (map[string]latestSnapshot, error) {
+	mapping := map[string]latestSnapshot{}
+
+	// target dataset is not created in dry run mode
+	exists, err := target.IsDatasetExists(targetDatasetName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return mapping, nil
+	}
+
+	mappings, err := target.GetDatasetPropertiesRecursive(
+		[]zfs.PropertyRequest{
+			{Name: constants.GUID, System: true, Snapshot: true},
+			{Name: constants.CreateTXG, System: true, Snapshot: true},
+			{Name: constants.Managed, Local: true, Snapshot: true},
+		},
+		targetDatasetName,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	mapping := map[string]string{}
-	for _, targetSnapshot := range targetSnapshots {
-		parts := strings.SplitN(
-			strings.TrimPrefix(targetSnapshot, targetDatasetName),
-			"@",
-			2,
+	var (
+		unmanaged = map[string][]string{}
+		datasets  = []string{}
+	)
+
+	for _, properties := range mappings {
+		dataset, name, err := zfs.SplitSnapshotName(properties.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		var (
+			snapshot = latestSnapshot{Name: name}
+			managed  bool
+			source   = strings.TrimPrefix(
+				strings.TrimPrefix(dataset, targetDatasetName),
+				"/",
+			)
 		)
 
-		if len(parts) != 2 {
-			return nil, karma.
-				Describe("snapshot", targetSnapshot).
-				Format(
-					err,
-					"internal error: snapshot doesn't contain '@'",
+		for _, property := range properties.Properties {
+			switch property.Name {
+			case constants.GUID:
+				snapshot.GUID = property.Value
+
+			case constants.CreateTXG:
+				snapshot.CreateTXG, err = strconv.ParseUint(
+					property.Value,
+					10,
+					64,
 				)
+				if err != nil {
+					return nil, karma.
+						Describe("snapshot", properties.Source).
+						Format(
+							err,
+							"unable to parse createtxg",
+						)
+				}
+
+			case constants.Managed:
+				managed = true
+			}
+		}
+
+		if !managed {
+			if unmanaged[dataset] == nil {
+				datasets = append(datasets, dataset)
+			}
+
+			unmanaged[dataset] = append(unmanaged[dataset], name)
+
+			continue
+		}
+
+		if latest, ok := mapping[source]; ok {
+			if latest.CreateTXG > snapshot.CreateTXG {
+				continue
+			}
 		}
 
-		mapping[strings.TrimPrefix(parts[0], "/")] = parts[1]
+		mapping[source] = snapshot
+	}
+
+	for _, dataset := range datasets {
+		log.Warningf(
+			"dataset %q has %d %s not made by zeus, "+
+				"they are never used as incremental base: %s",
+			dataset,
+			len(unmanaged[dataset]),
+			text.Pluralize("snapshot", len(unmanaged[dataset])),
+			strings.Join(unmanaged[dataset], ", "),
+		)
 	}
 
 	return mapping, nil
@@ -415,9 +985,10 @@ func getLatestTargetSnapshotsBySource(
 
 func loadEncryptionKey(
 	config *config.Config,
+	host zfs.Backend,
 	dataset string,
 ) (bool, string, error) {
-	mappings, err := zfs.GetDatasetProperties([]zfs.PropertyRequest{
+	mappings, err := host.GetDatasetProperties([]zfs.PropertyRequest{
 		{Name: constants.Keystatus, System: true, Filesystem: true},
 		{Name: constants.EncryptionRoot, System: true, Filesystem: true},
 	}, dataset)
@@ -474,7 +1045,7 @@ func loadEncryptionKey(
 			)
 	}
 
-	err = zfs.LoadKey(encryptionRoot, key)
+	err = host.LoadKey(encryptionRoot, key)
 	if err != nil {
 		return false, "", karma.Format(
 			err,
@@ -0,0 +1,1026 @@
+package backup
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/zfs"
+	"github.com/reconquest/zeus/pkg/zfs/fake"
+)
+
+const (
+	testSourceDataset = "z/home"
+)
+
+var (
+	testTime = time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+)
+
+func newTestBackup(
+	t *testing.T,
+) (*config.Config, *fake.Backend, *fake.Backend) {
+	config, err := config.LoadConfig("/nonexistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.TargetDataset = "vm"
+
+	clock := func() time.Time {
+		return testTime
+	}
+
+	source := fake.New()
+	source.Now = clock
+	source.AddPool("z", true)
+
+	target := fake.New()
+	target.Now = clock
+	target.AddPool("zbackup", false)
+
+	err = source.EnsureDatasetExists(testSourceDataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = source.SetDatasetProperty(testSourceDataset, constants.Backup, "on")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config, source, target
+}
+
+// runTestBackup advances clock of given backends by a minute and runs
+// backup at that time, since snapshots are named after time of backup.
+func runTestBackup(
+	t *testing.T,
+	config *config.Config,
+	source *fake.Backend,
+	target *fake.Backend,
+	opts ...Opts,
+) error {
+	now := source.Now().Add(time.Minute)
+
+	clock := func() time.Time {
+		return now
+	}
+
+	source.Now = clock
+	target.Now = clock
+
+	opts = append(
+		opts,
+		OptBackend{Source: source, Target: target},
+		OptForce(true),
+		OptNow(clock),
+	)
+
+	return Backup(config, opts...)
+}
+
+// getTestTargetDataset returns name of the target dataset which holds
+// backups of the test source dataset.
+func getTestTargetDataset(t *testing.T, source *fake.Backend) string {
+	return getTestPoolDataset(t, source, "zbackup")
+}
+
+// getTestPoolDataset returns name of the dataset in given target pool which
+// holds backups of the test source dataset.
+func getTestPoolDataset(
+	t *testing.T,
+	source *fake.Backend,
+	pool string,
+) string {
+	mappings, err := source.GetDatasetProperties([]zfs.PropertyRequest{
+		{Name: constants.GUID, System: true},
+	}, testSourceDataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mapping := range mappings {
+		for _, property := range mapping.Properties {
+			if property.Name == constants.GUID {
+				return pool + "/vm/" + getNamespace(property.Value) + "/" +
+					testSourceDataset
+			}
+		}
+	}
+
+	t.Fatal("guid of source dataset is not reported")
+
+	return ""
+}
+
+func listTestSnapshots(
+	t *testing.T,
+	backend *fake.Backend,
+	dataset string,
+) []string {
+	snapshots, err := backend.ListSnapshots(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot[strings.Index(snapshot, "@"):])
+	}
+
+	return names
+}
+
+func listTestHolds(
+	t *testing.T,
+	backend *fake.Backend,
+	snapshot string,
+) []string {
+	holds, err := backend.ListHolds(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return holds
+}
+
+func importTestPool(t *testing.T, backend *fake.Backend, pool string) {
+	err := backend.ImportPool(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackup_FullSend(t *testing.T) {
+	config, source, target := newTestBackup(t)
+
+	err := source.Write(testSourceDataset, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importTestPool(t, target, "zbackup")
+
+	sourceSnapshots := listTestSnapshots(t, source, testSourceDataset)
+	if len(sourceSnapshots) != 1 {
+		t.Fatalf("expected one source snapshot, got %v", sourceSnapshots)
+	}
+
+	if !strings.HasPrefix(sourceSnapshots[0], "@"+config.SnapshotPrefix) {
+		t.Errorf("unexpected source snapshot name: %s", sourceSnapshots[0])
+	}
+
+	targetSnapshots := listTestSnapshots(
+		t,
+		target,
+		getTestTargetDataset(t, source),
+	)
+	if !reflect.DeepEqual(targetSnapshots, sourceSnapshots) {
+		t.Errorf(
+			"target snapshots %v don't match source snapshots %v",
+			targetSnapshots,
+			sourceSnapshots,
+		)
+	}
+
+	holds := listTestHolds(t, source, testSourceDataset+sourceSnapshots[0])
+	if !reflect.DeepEqual(holds, []string{config.HoldTag}) {
+		t.Errorf("unexpected holds of source snapshot: %v", holds)
+	}
+}
+
+func TestBackup_IncrementalSend(t *testing.T) {
+	config, source, target := newTestBackup(t)
+
+	for i := 0; i < 3; i++ {
+		err := source.Write(testSourceDataset, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = runTestBackup(t, config, source, target, OptNoExport(true))
+		if err != nil {
+			t.Fatalf("backup %d: %s", i, err)
+		}
+	}
+
+	sourceSnapshots := listTestSnapshots(t, source, testSourceDataset)
+	targetSnapshots := listTestSnapshots(
+		t,
+		target,
+		getTestTargetDataset(t, source),
+	)
+
+	if len(targetSnapshots) != 3 {
+		t.Fatalf("expected three target snapshots, got %v", targetSnapshots)
+	}
+
+	// only the latest snapshot is kept on source by default, it is held as
+	// incremental base for the next backup
+	if !reflect.DeepEqual(sourceSnapshots, targetSnapshots[2:]) {
+		t.Errorf(
+			"expected source snapshots %v, got %v",
+			targetSnapshots[2:],
+			sourceSnapshots,
+		)
+	}
+
+	holds := listTestHolds(t, source, testSourceDataset+sourceSnapshots[0])
+	if !reflect.DeepEqual(holds, []string{config.HoldTag}) {
+		t.Errorf("unexpected holds of source snapshot: %v", holds)
+	}
+}
+
+func TestBackup_ResumesInterruptedReceive(t *testing.T) {
+	config, source, target := newTestBackup(t)
+
+	err := source.Write(testSourceDataset, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target.InterruptReceive = true
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err == nil {
+		t.Fatal("expected interrupted backup to fail")
+	}
+
+	token, err := target.GetResumeToken(getTestTargetDataset(t, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token == "" {
+		t.Fatal("expected resume token on target dataset")
+	}
+
+	interrupted := listTestSnapshots(t, source, testSourceDataset)
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err = target.GetResumeToken(getTestTargetDataset(t, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		t.Errorf("unexpected resume token after resume: %q", token)
+	}
+
+	// resumed receive doesn't create new snapshot
+	sourceSnapshots := listTestSnapshots(t, source, testSourceDataset)
+	if !reflect.DeepEqual(sourceSnapshots, interrupted) {
+		t.Errorf(
+			"expected source snapshots %v, got %v",
+			interrupted,
+			sourceSnapshots,
+		)
+	}
+
+	targetSnapshots := listTestSnapshots(
+		t,
+		target,
+		getTestTargetDataset(t, source),
+	)
+	if !reflect.DeepEqual(targetSnapshots, sourceSnapshots) {
+		t.Errorf(
+			"target snapshots %v don't match source snapshots %v",
+			targetSnapshots,
+			sourceSnapshots,
+		)
+	}
+
+	// chain continues from the resumed snapshot
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetSnapshots = listTestSnapshots(
+		t,
+		target,
+		getTestTargetDataset(t, source),
+	)
+	if len(targetSnapshots) != 2 {
+		t.Errorf("expected two target snapshots, got %v", targetSnapshots)
+	}
+}
+
+func TestBackup_ImportsAndExportsPool(t *testing.T) {
+	config, source, target := newTestBackup(t)
+
+	err := runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := target.GetImportedPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(imported) != 0 {
+		t.Errorf("expected pool to be exported after backup, got %v", imported)
+	}
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err = target.GetImportedPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(imported, []string{"zbackup"}) {
+		t.Errorf("expected pool to be left imported, got %v", imported)
+	}
+
+	if snapshots := listTestSnapshots(
+		t,
+		target,
+		getTestTargetDataset(t, source),
+	); len(snapshots) != 2 {
+		t.Errorf("expected two target snapshots, got %v", snapshots)
+	}
+}
+
+func TestBackup_PermanentPoolIsNotImported(t *testing.T) {
+	permanent, source, target := newTestBackup(t)
+
+	permanent.TargetMode = config.TargetModePermanent
+
+	err := runTestBackup(t, permanent, source, target)
+	if err == nil {
+		t.Fatal("expected backup to exported permanent pool to fail")
+	}
+
+	imported, err := target.GetImportedPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(imported) != 0 {
+		t.Errorf("expected permanent pool not to be imported, got %v", imported)
+	}
+}
+
+func TestBackup_InsufficientSpaceLeavesNothingBehind(t *testing.T) {
+	for _, policy := range []string{
+		config.OnInsufficientSpaceFail,
+		config.OnInsufficientSpaceSkip,
+	} {
+		t.Run(policy, func(t *testing.T) {
+			fail := policy == config.OnInsufficientSpaceFail
+
+			config, source, target := newTestBackup(t)
+
+			config.OnInsufficientSpace = policy
+
+			err := target.SetPoolSize("zbackup", 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = source.Write(testSourceDataset, 2000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = runTestBackup(t, config, source, target)
+			if fail && err == nil {
+				t.Fatal("expected backup to fail")
+			}
+
+			snapshots := listTestSnapshots(t, source, testSourceDataset)
+			if len(snapshots) != 0 {
+				t.Errorf("unexpected source snapshots: %v", snapshots)
+			}
+
+			importTestPool(t, target, "zbackup")
+
+			// namespace of the source dataset is created only when
+			// backup is actually run
+			namespace := path.Dir(getTestTargetDataset(t, source))
+
+			exists, err := target.IsDatasetExists(namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if exists {
+				t.Errorf("unexpected target dataset %q", namespace)
+			}
+		})
+	}
+}
+
+func TestBackup_CompressesStreamToRemoteTarget(t *testing.T) {
+	for _, compression := range []string{"zstd", "lz4"} {
+		t.Run(compression, func(t *testing.T) {
+			config, source, target := newTestBackup(t)
+
+			config.Remote.Compression = compression
+
+			target.Remote = true
+
+			importTestPool(t, target, "zbackup")
+
+			for i := 0; i < 2; i++ {
+				err := source.Write(testSourceDataset, 1000)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = runTestBackup(t, config, source, target)
+				if err != nil {
+					t.Fatalf("backup %d: %s", i, err)
+				}
+			}
+
+			snapshots := listTestSnapshots(
+				t,
+				target,
+				getTestTargetDataset(t, source),
+			)
+			if len(snapshots) != 2 {
+				t.Errorf("expected two target snapshots, got %v", snapshots)
+			}
+		})
+	}
+}
+
+func TestBackup_InsufficientSpaceHousekeeping(t *testing.T) {
+	policy := config.OnInsufficientSpaceHousekeeping
+
+	config, source, target := newTestBackup(t)
+
+	config.OnInsufficientSpace = policy
+
+	for i := 0; i < 3; i++ {
+		err := source.Write(testSourceDataset, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = runTestBackup(t, config, source, target, OptNoExport(true))
+		if err != nil {
+			t.Fatalf("backup %d: %s", i, err)
+		}
+	}
+
+	targetDataset := getTestTargetDataset(t, source)
+
+	snapshots := listTestSnapshots(t, target, targetDataset)
+
+	available, err := zfs.GetAvailableSpace(target, "zbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the next stream fits only if the oldest snapshot is destroyed
+	err = target.SetPoolSize("zbackup", fake.PoolSize-available+1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = source.Write(testSourceDataset, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := listTestSnapshots(t, target, targetDataset)
+	if len(result) != 3 || !reflect.DeepEqual(result[:2], snapshots[1:]) {
+		t.Errorf(
+			"expected the oldest of %v to be destroyed, got %v",
+			snapshots,
+			result,
+		)
+	}
+}
+
+// listTestChains returns target datasets of the test source dataset in all
+// incremental chains.
+func listTestChains(t *testing.T, target *fake.Backend) []string {
+	datasets, err := listTargetDatasets(target, "zbackup/vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chains := []string{}
+	for _, dataset := range datasets {
+		if strings.HasSuffix(dataset, "/"+testSourceDataset) {
+			chains = append(chains, "zbackup/vm/"+dataset)
+		}
+	}
+
+	return chains
+}
+
+func setTestOnBrokenChain(t *testing.T, source *fake.Backend, value string) {
+	err := source.SetDatasetProperty(
+		testSourceDataset,
+		constants.BackupOnBrokenChain,
+		value,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackup_RotatesTargets(t *testing.T) {
+	targets := []config.Target{
+		{Pool: "zbackup-a", Dataset: "vm", HoldTag: "zeus:zbackup-a"},
+		{Pool: "zbackup-b", Dataset: "vm", HoldTag: "zeus:zbackup-b"},
+	}
+
+	config, source, target := newTestBackup(t)
+
+	config.Targets = targets
+
+	target.AddPool("zbackup-a", false)
+	target.AddPool("zbackup-b", false)
+
+	rotate := func(attached string, detached string) {
+		err := target.DetachPool(detached)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = target.AttachPool(attached)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotate("zbackup-a", "zbackup-b")
+
+	err := runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotate("zbackup-b", "zbackup-a")
+
+	err = runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first snapshot is incremental base for the detached pool, so it's
+	// kept held until that pool gets newer snapshot
+	snapshots := listTestSnapshots(t, source, testSourceDataset)
+	if len(snapshots) != 2 {
+		t.Fatalf("expected two source snapshots, got %v", snapshots)
+	}
+
+	for i, tag := range []string{"zeus:zbackup-a", "zeus:zbackup-b"} {
+		holds := listTestHolds(t, source, testSourceDataset+snapshots[i])
+		if !reflect.DeepEqual(holds, []string{tag}) {
+			t.Errorf("unexpected holds of %q: %v", snapshots[i], holds)
+		}
+	}
+
+	rotate("zbackup-a", "zbackup-b")
+
+	err = runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := listTestSnapshots(t, source, testSourceDataset)
+	if len(rotated) != 2 || rotated[0] != snapshots[1] {
+		t.Fatalf("expected only base of zbackup-b to be kept, got %v", rotated)
+	}
+
+	for i, tag := range []string{"zeus:zbackup-b", "zeus:zbackup-a"} {
+		holds := listTestHolds(t, source, testSourceDataset+rotated[i])
+		if !reflect.DeepEqual(holds, []string{tag}) {
+			t.Errorf("unexpected holds of %q: %v", rotated[i], holds)
+		}
+	}
+
+	importTestPool(t, target, "zbackup-a")
+
+	// zbackup-a has got incremental backup on top of the first snapshot
+	received := listTestSnapshots(
+		t,
+		target,
+		getTestPoolDataset(t, source, "zbackup-a"),
+	)
+	if !reflect.DeepEqual(received, []string{snapshots[0], rotated[1]}) {
+		t.Errorf("unexpected snapshots on zbackup-a: %v", received)
+	}
+}
+
+func TestBackup_KeepsBaseOfDetachedTargetWithoutHold(t *testing.T) {
+	targets := []config.Target{
+		{Pool: "zbackup-a", Dataset: "vm", HoldTag: "zeus:zbackup-a"},
+		{Pool: "zbackup-b", Dataset: "vm", HoldTag: "zeus:zbackup-b"},
+	}
+
+	config, source, target := newTestBackup(t)
+
+	config.Targets = targets
+
+	target.AddPool("zbackup-a", false)
+
+	err := runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := testSourceDataset +
+		listTestSnapshots(t, source, testSourceDataset)[0]
+
+	// hold can be released manually, snapshot is still known to be sent to
+	// zbackup-a, so it's kept as incremental base for that pool
+	err = source.Release("zeus:zbackup-a", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = target.DetachPool("zbackup-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target.AddPool("zbackup-b", false)
+
+	err = runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots := listTestSnapshots(t, source, testSourceDataset)
+	if len(snapshots) != 2 || testSourceDataset+snapshots[0] != base {
+		t.Errorf("expected base of zbackup-a to be kept, got %v", snapshots)
+	}
+}
+
+func TestBackup_RecursiveReceivesChildrenWithoutMountpoint(t *testing.T) {
+	config, source, target := newTestBackup(t)
+
+	child := testSourceDataset + "/alice"
+
+	err := source.EnsureDatasetExists(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, property := range []struct {
+		dataset string
+		name    string
+		value   string
+	}{
+		{testSourceDataset, constants.Backup, "recursive"},
+		{testSourceDataset, constants.Mountpoint, "/home"},
+		{child, constants.Mountpoint, "/home/alice"},
+	} {
+		err := source.SetDatasetProperty(
+			property.dataset,
+			property.name,
+			property.value,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// child which is added between backups is sent in full
+	err = source.EnsureDatasetExists(testSourceDataset + "/bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest := listTestSnapshots(t, source, testSourceDataset)[0]
+	targetDataset := getTestTargetDataset(t, source)
+
+	for _, dataset := range []string{
+		targetDataset,
+		targetDataset + "/alice",
+		targetDataset + "/bob",
+	} {
+		snapshots := listTestSnapshots(t, target, dataset)
+		if len(snapshots) == 0 || snapshots[len(snapshots)-1] != latest {
+			t.Errorf("unexpected snapshots of %q: %v", dataset, snapshots)
+		}
+
+		// received datasets would be mounted over source ones otherwise
+		mappings, err := target.GetDatasetProperties([]zfs.PropertyRequest{
+			{Name: constants.Mountpoint, Local: true, Inherited: true},
+		}, dataset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(mappings) != 0 {
+			t.Errorf("mountpoint of %q is received: %v", dataset, mappings)
+		}
+	}
+}
+
+func TestGetLatestTargetSnapshotsBySource(t *testing.T) {
+	_, _, target := newTestBackup(t)
+
+	importTestPool(t, target, "zbackup")
+
+	dataset := "zbackup/vm/" + testSourceDataset
+
+	err := target.EnsureDatasetExists(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// names sort in other order than snapshots are made, newer snapshot
+	// which is not made by zeus is never used as a base
+	for _, snapshot := range []struct {
+		name    string
+		managed bool
+	}{
+		{"zeus:b", true},
+		{"zeus:a", true},
+		{"manual", false},
+	} {
+		err := target.CreateSnapshot(dataset + "@" + snapshot.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !snapshot.managed {
+			continue
+		}
+
+		err = target.SetDatasetProperty(
+			dataset+"@"+snapshot.name,
+			constants.Managed,
+			"yes",
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := getLatestTargetSnapshotsBySource(target, "zbackup/vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if latest := snapshots[testSourceDataset]; latest.Name != "zeus:a" {
+		t.Errorf("unexpected latest snapshot: %+v", latest)
+	}
+}
+
+func TestBackup_ChainBaseWithDifferentName(t *testing.T) {
+	config, source, target := newTestBackup(t)
+
+	for i := 0; i < 2; i++ {
+		err := runTestBackup(t, config, source, target, OptNoExport(true))
+		if err != nil {
+			t.Fatalf("backup %d: %s", i, err)
+		}
+	}
+
+	base := listTestSnapshots(t, source, testSourceDataset)[0]
+
+	err := source.Release(config.HoldTag, testSourceDataset+base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = source.RenameSnapshot(
+		testSourceDataset+base,
+		testSourceDataset+"@renamed",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chains := listTestChains(t, target)
+	if len(chains) != 1 {
+		t.Fatalf("expected single chain, got %v", chains)
+	}
+
+	if snapshots := listTestSnapshots(t, target, chains[0]); len(snapshots) != 3 {
+		t.Errorf("expected three target snapshots, got %v", snapshots)
+	}
+}
+
+func TestBackup_ChainTargetHasNewerSnapshots(t *testing.T) {
+	for _, policy := range []string{"fail", "full"} {
+		t.Run(policy, func(t *testing.T) {
+			config, source, target := newTestBackup(t)
+
+			setTestOnBrokenChain(t, source, policy)
+
+			err := runTestBackup(t, config, source, target, OptNoExport(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			targetDataset := getTestTargetDataset(t, source)
+
+			err = target.CreateSnapshot(targetDataset + "@manual")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = runTestBackup(t, config, source, target, OptNoExport(true))
+
+			assertTestBrokenChain(t, target, targetDataset, policy, err)
+
+			// snapshot made after the base is never rolled back
+			snapshots := listTestSnapshots(t, target, targetDataset)
+			if len(snapshots) != 2 || snapshots[1] != "@manual" {
+				t.Errorf("unexpected snapshots of old chain: %v", snapshots)
+			}
+		})
+	}
+}
+
+func TestBackup_ChainBaseIsMissingOnSource(t *testing.T) {
+	for _, policy := range []string{"fail", "full"} {
+		t.Run(policy, func(t *testing.T) {
+			config, source, target := newTestBackup(t)
+
+			setTestOnBrokenChain(t, source, policy)
+
+			err := runTestBackup(t, config, source, target, OptNoExport(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			base := testSourceDataset +
+				listTestSnapshots(t, source, testSourceDataset)[0]
+
+			err = source.Release(config.HoldTag, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = source.DestroyDataset(base)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = runTestBackup(t, config, source, target, OptNoExport(true))
+
+			assertTestBrokenChain(
+				t,
+				target,
+				getTestTargetDataset(t, source),
+				policy,
+				err,
+			)
+		})
+	}
+}
+
+func TestBackup_ChainTargetHasOnlyUnmanagedSnapshots(t *testing.T) {
+	for _, policy := range []string{"fail", "full"} {
+		t.Run(policy, func(t *testing.T) {
+			config, source, target := newTestBackup(t)
+
+			setTestOnBrokenChain(t, source, policy)
+
+			importTestPool(t, target, "zbackup")
+
+			targetDataset := getTestTargetDataset(t, source)
+
+			err := target.EnsureDatasetExists(targetDataset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = target.CreateSnapshot(targetDataset + "@manual")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = runTestBackup(t, config, source, target, OptNoExport(true))
+
+			assertTestBrokenChain(t, target, targetDataset, policy, err)
+		})
+	}
+}
+
+func TestBackup_ChainResumesInterruptedFullSend(t *testing.T) {
+	config, source, target := newTestBackup(t)
+
+	setTestOnBrokenChain(t, source, "full")
+
+	err := runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = target.CreateSnapshot(getTestTargetDataset(t, source) + "@manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target.InterruptReceive = true
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err == nil {
+		t.Fatal("expected interrupted backup to fail")
+	}
+
+	chains := listTestChains(t, target)
+	if len(chains) != 2 {
+		t.Fatalf("expected new chain to be started, got %v", chains)
+	}
+
+	err = runTestBackup(t, config, source, target, OptNoExport(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed := listTestChains(t, target); !reflect.DeepEqual(
+		resumed,
+		chains,
+	) {
+		t.Fatalf("expected receive to be resumed in %v, got %v", chains, resumed)
+	}
+
+	if snapshots := listTestSnapshots(t, target, chains[1]); len(snapshots) != 1 {
+		t.Errorf("expected resumed snapshot in new chain, got %v", snapshots)
+	}
+}
+
+// assertTestBrokenChain checks that backup with broken chain either has
+// failed or has started new chain by full send depending on given policy.
+func assertTestBrokenChain(
+	t *testing.T,
+	target *fake.Backend,
+	targetDataset string,
+	policy string,
+	err error,
+) {
+	t.Helper()
+
+	chains := listTestChains(t, target)
+
+	if policy == "fail" {
+		if err == nil {
+			t.Fatal("expected backup with broken chain to fail")
+		}
+
+		if !reflect.DeepEqual(chains, []string{targetDataset}) {
+			t.Errorf("unexpected new chain: %v", chains)
+		}
+
+		return
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespace := strings.TrimSuffix(targetDataset, "/"+testSourceDataset)
+
+	if len(chains) != 2 || chains[0] != targetDataset ||
+		!strings.HasPrefix(chains[1], namespace+chainSeparator) {
+		t.Fatalf("expected new chain to be started, got %v", chains)
+	}
+
+	snapshots := listTestSnapshots(t, target, chains[1])
+	if len(snapshots) != 1 {
+		t.Errorf("expected full send into new chain, got %v", snapshots)
+	}
+}
@@ -0,0 +1,214 @@
+package backup
+
+import (
+	"sort"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/backup/errs"
+	"github.com/reconquest/zeus/pkg/backup/housekeeping"
+	"github.com/reconquest/zeus/pkg/backup/operation"
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/formatting"
+	"github.com/reconquest/zeus/pkg/text"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+// checkCapacity estimates size of streams of given operations and compares
+// it with space available on target pool, so receive doesn't fill the pool
+// and leave half-received datasets behind. Operations which can't be
+// estimated are failed, returned are indexes of operations to run. Snapshots
+// made to estimate operations which are not run are destroyed.
+func checkCapacity(
+	target zfs.Backend,
+	pool string,
+	holdTag string,
+	policy string,
+	operations []BackupOperationWithHousekeeping,
+	queue []int,
+	results []backupResult,
+	failFast bool,
+) ([]int, error) {
+	selected, err := selectByCapacity(
+		target,
+		pool,
+		holdTag,
+		policy,
+		operations,
+		queue,
+		results,
+		failFast,
+	)
+
+	discardSnapshots(operations, queue, selected)
+
+	return selected, err
+}
+
+func selectByCapacity(
+	target zfs.Backend,
+	pool string,
+	holdTag string,
+	policy string,
+	operations []BackupOperationWithHousekeeping,
+	queue []int,
+	results []backupResult,
+	failFast bool,
+) ([]int, error) {
+	var (
+		sizes    = map[int]uint64{}
+		selected = []int{}
+		required uint64
+	)
+
+	for _, i := range queue {
+		size, err := operations[i].Estimate()
+		if err != nil {
+			err = karma.Format(
+				err,
+				"unable to estimate size of send stream",
+			)
+
+			operations[i].NewLog("{backup}").Error(err)
+
+			results[i].Status = backupStatusFailed
+			results[i].Reason = err.Error()
+
+			if failFast {
+				return nil, err
+			}
+
+			continue
+		}
+
+		operations[i].NewLog("{backup}").Debugf(
+			"estimated stream size: %s",
+			formatting.Size(size),
+		)
+
+		sizes[i] = size
+		required += size
+
+		selected = append(selected, i)
+	}
+
+	available, err := zfs.GetAvailableSpace(target, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof(
+		"%s to send, %s available on target pool %q",
+		formatting.Size(required),
+		formatting.Size(available),
+		pool,
+	)
+
+	if required <= available {
+		return selected, nil
+	}
+
+	insufficient := errs.InsufficientSpace{
+		Pool:      pool,
+		Required:  required,
+		Available: available,
+	}
+
+	switch policy {
+	case config.OnInsufficientSpaceSkip:
+		return skipLargest(selected, sizes, results, available, insufficient)
+
+	case config.OnInsufficientSpaceHousekeeping:
+		log.Warningf(
+			"%s, destroying the oldest snapshots on target pool",
+			insufficient,
+		)
+
+		backups := []operation.Backup{}
+		for _, i := range selected {
+			backups = append(backups, operations[i].Backup)
+		}
+
+		available, err = housekeeping.FreeTarget(
+			holdTag,
+			backups,
+			available,
+			required,
+		)
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to free space on target pool",
+			)
+		}
+
+		if required <= available {
+			return selected, nil
+		}
+
+		insufficient.Available = available
+	}
+
+	return nil, insufficient
+}
+
+// skipLargest skips the largest operations until the rest of them fit into
+// available space.
+func skipLargest(
+	selected []int,
+	sizes map[int]uint64,
+	results []backupResult,
+	available uint64,
+	insufficient errs.InsufficientSpace,
+) ([]int, error) {
+	sort.SliceStable(selected, func(i, j int) bool {
+		return sizes[selected[i]] > sizes[selected[j]]
+	})
+
+	required := insufficient.Required
+
+	for len(selected) > 0 && required > available {
+		i := selected[0]
+
+		results[i].Reason = "not enough space on target pool: " +
+			formatting.Size(sizes[i]) + " to send"
+
+		required -= sizes[i]
+		selected = selected[1:]
+	}
+
+	log.Warningf(
+		"%s, skipping the largest %d %s",
+		insufficient,
+		len(sizes)-len(selected),
+		text.Pluralize("dataset", len(sizes)-len(selected)),
+	)
+
+	// datasets are backed up in the original order
+	sort.Ints(selected)
+
+	return selected, nil
+}
+
+// discardSnapshots destroys snapshots made to estimate queued operations
+// which are not selected to run.
+func discardSnapshots(
+	operations []BackupOperationWithHousekeeping,
+	queue []int,
+	selected []int,
+) {
+	run := map[int]bool{}
+	for _, i := range selected {
+		run[i] = true
+	}
+
+	for _, i := range queue {
+		if run[i] {
+			continue
+		}
+
+		err := operations[i].Discard()
+		if err != nil {
+			operations[i].NewLog("{backup}").Error(err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/formatting"
 	"github.com/reconquest/zeus/pkg/zfs"
 )
 
@@ -29,3 +30,62 @@ func UnsupportedPropertyValue(
 			),
 		)
 }
+
+// InvalidPropertyValue is returned when value of the property can't be
+// parsed.
+func InvalidPropertyValue(property zfs.Property, err error) error {
+	return karma.
+		Describe("dataset", property.Source).
+		Describe("property", property.Name).
+		Describe("value", property.Value).
+		Format(
+			err,
+			"invalid property value",
+		)
+}
+
+// DatasetsFailed is returned when backup of some datasets has failed while
+// other datasets were processed anyway.
+type DatasetsFailed struct {
+	Failed int
+	Total  int
+}
+
+func (err DatasetsFailed) Error() string {
+	return fmt.Sprintf(
+		"backup failed for %d of %d datasets",
+		err.Failed,
+		err.Total,
+	)
+}
+
+// StatusCritical is returned when status check has found problems with
+// backups or target pool.
+type StatusCritical struct {
+	Problems []string
+}
+
+func (err StatusCritical) Error() string {
+	return fmt.Sprintf(
+		"status check found %d problem(s):\n%s",
+		len(err.Problems),
+		strings.Join(err.Problems, "\n"),
+	)
+}
+
+// InsufficientSpace is returned when estimated size of streams exceeds space
+// available on target pool.
+type InsufficientSpace struct {
+	Pool      string
+	Required  uint64
+	Available uint64
+}
+
+func (err InsufficientSpace) Error() string {
+	return fmt.Sprintf(
+		"not enough space on target pool %q: %s required, %s available",
+		err.Pool,
+		formatting.Size(err.Required),
+		formatting.Size(err.Available),
+	)
+}
@@ -0,0 +1,102 @@
+package housekeeping
+
+import (
+	"github.com/kovetskiy/lorg"
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/backup/operation"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+// destroySnapshot destroys given snapshot releasing zeus hold if it's
+// placed. Snapshot which has been just made by backup operation or which is
+// held by another tag is never destroyed, in which case false is returned.
+// Snapshots of descendants made by recursive backup are destroyed along with
+// given snapshot, unless any of them is held by another tag.
+func destroySnapshot(
+	log *lorg.Log,
+	host zfs.Backend,
+	holdTag string,
+	operation operation.Backup,
+	snapshot string,
+) (bool, error) {
+	_, candidate, err := zfs.SplitSnapshotName(snapshot)
+	if err != nil {
+		return false, err
+	}
+
+	// doubled check that we're not deleting backup snapshot
+	if operation.Snapshot.Current == candidate {
+		return false, nil
+	}
+
+	snapshots, err := getSnapshotTree(host, operation.Recursive, snapshot)
+	if err != nil {
+		return false, err
+	}
+
+	held := map[string]bool{}
+
+	for _, snapshot := range snapshots {
+		holds, err := host.ListHolds(snapshot)
+		if err != nil {
+			return false, karma.Format(
+				err,
+				"unable to list holds of snapshot %q",
+				snapshot,
+			)
+		}
+
+		for _, tag := range holds {
+			if tag == holdTag {
+				held[snapshot] = true
+
+				continue
+			}
+
+			// snapshot can be held by zeus for another target backup pool,
+			// it is still required for incremental backup to that pool
+			log.Infof(
+				"keeping old snapshot %q because it's held by %q tag",
+				snapshot,
+				tag,
+			)
+
+			return false, nil
+		}
+	}
+
+	log.Infof("destroying old snapshot %q", snapshot)
+
+	// snapshots of descendants are destroyed first, so given snapshot is
+	// kept if any of them can't be destroyed
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if held[snapshots[i]] {
+			log.Warningf(
+				"snapshot %q is still held by %q tag, releasing it",
+				snapshots[i],
+				holdTag,
+			)
+
+			err := host.Release(holdTag, snapshots[i])
+			if err != nil {
+				return false, karma.Format(
+					err,
+					"unable to release hold tag %q on %q",
+					holdTag,
+					snapshots[i],
+				)
+			}
+		}
+
+		err = host.DestroyDataset(snapshots[i])
+		if err != nil {
+			return false, karma.Format(
+				err,
+				"unabled to destroy snapshot %q during cleanup",
+				snapshots[i],
+			)
+		}
+	}
+
+	return true, nil
+}
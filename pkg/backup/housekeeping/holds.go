@@ -3,13 +3,21 @@ package housekeeping
 import (
 	"fmt"
 
+	"github.com/kovetskiy/lorg"
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/zeus/pkg/backup/operation"
 	"github.com/reconquest/zeus/pkg/zfs"
 )
 
 func ApplyHolds(tag string, operation operation.Backup) error {
-	err := hold(tag, fmt.Sprintf(
+	log := operation.NewLog("{housekeeping}")
+
+	var (
+		source = operation.Hosts.Source
+		target = operation.Hosts.Target
+	)
+
+	err := hold(log, source, tag, operation.Recursive, fmt.Sprintf(
 		"%s@%s",
 		operation.Source,
 		operation.Snapshot.Current,
@@ -18,7 +26,7 @@ func ApplyHolds(tag string, operation operation.Backup) error {
 		return err
 	}
 
-	err = hold(tag, fmt.Sprintf(
+	err = hold(log, target, tag, operation.Recursive, fmt.Sprintf(
 		"%s/%s@%s",
 		operation.Target,
 		operation.Source,
@@ -32,7 +40,7 @@ func ApplyHolds(tag string, operation operation.Backup) error {
 		return nil
 	}
 
-	err = release(tag, fmt.Sprintf(
+	err = release(log, target, tag, operation.Recursive, fmt.Sprintf(
 		"%s/%s@%s",
 		operation.Target,
 		operation.Source,
@@ -42,7 +50,7 @@ func ApplyHolds(tag string, operation operation.Backup) error {
 		return err
 	}
 
-	err = release(tag, fmt.Sprintf(
+	err = release(log, source, tag, operation.Recursive, fmt.Sprintf(
 		"%s@%s",
 		operation.Source,
 		operation.Snapshot.Base,
@@ -54,26 +62,81 @@ func ApplyHolds(tag string, operation operation.Backup) error {
 	return nil
 }
 
-func hold(tag string, snapshot string) error {
-	log.Debugf("putting hold on snapshot %q", snapshot)
+func hold(
+	log *lorg.Log,
+	host zfs.Backend,
+	tag string,
+	recursive bool,
+	snapshot string,
+) error {
+	snapshots, err := getSnapshotTree(host, recursive, snapshot)
+	if err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		log.Debugf("putting hold on snapshot %q", snapshot)
+
+		err := host.Hold(tag, snapshot)
+		if err != nil {
+			return karma.
+				Describe("tag", tag).Format(
+				err,
+				"unable to put hold on snapshot %q",
+				snapshot,
+			)
+		}
+	}
+
+	return nil
+}
 
-	err := zfs.Hold(tag, snapshot)
+func release(
+	log *lorg.Log,
+	host zfs.Backend,
+	tag string,
+	recursive bool,
+	snapshot string,
+) error {
+	snapshots, err := getSnapshotTree(host, recursive, snapshot)
 	if err != nil {
-		return karma.
-			Describe("tag", tag).Format(
-			err,
-			"unable to put hold on snapshot %q",
-			snapshot,
-		)
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		err := releaseSnapshot(log, host, tag, snapshot)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func release(tag string, snapshot string) error {
+func releaseSnapshot(
+	log *lorg.Log,
+	host zfs.Backend,
+	tag string,
+	snapshot string,
+) error {
 	log.Debugf("releasing hold on snapshot %q", snapshot)
 
-	held, err := zfs.HasHold(tag, snapshot)
+	// snapshot could be destroyed manually, which breaks incremental chain
+	exists, err := host.IsDatasetExists(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		log.Warningf(
+			"snapshot %q doesn't exist anymore, nothing to release",
+			snapshot,
+		)
+
+		return nil
+	}
+
+	held, err := host.HasHold(tag, snapshot)
 	if err != nil {
 		return karma.Format(
 			err,
@@ -86,7 +149,7 @@ func release(tag string, snapshot string) error {
 		return nil
 	}
 
-	err = zfs.Release(tag, snapshot)
+	err = host.Release(tag, snapshot)
 	if err != nil {
 		return karma.
 			Describe("tag", tag).
@@ -99,3 +162,31 @@ func release(tag string, snapshot string) error {
 
 	return nil
 }
+
+// getSnapshotTree returns given snapshot along with snapshots of descendants
+// made by recursive backup.
+func getSnapshotTree(
+	host zfs.Backend,
+	recursive bool,
+	snapshot string,
+) ([]string, error) {
+	if !recursive {
+		return []string{snapshot}, nil
+	}
+
+	snapshots, err := zfs.ListSnapshotTree(host, snapshot)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to list snapshots of descendants of %q",
+			snapshot,
+		)
+	}
+
+	// snapshot is not created in dry run mode
+	if len(snapshots) == 0 {
+		return []string{snapshot}, nil
+	}
+
+	return snapshots, nil
+}
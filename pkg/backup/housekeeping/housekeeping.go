@@ -46,12 +46,16 @@ func Configure(
 	case PolicyByCount{}.GetName():
 		constructor = NewPolicyByCount
 
+	case PolicyByAge{}.GetName():
+		constructor = NewPolicyByAge
+
 	default:
 		return nil, errs.UnsupportedPropertyValue(
 			*property,
 			[]string{
 				PolicyNone{}.GetName(),
 				PolicyByCount{}.GetName(),
+				PolicyByAge{}.GetName(),
 			},
 		)
 	}
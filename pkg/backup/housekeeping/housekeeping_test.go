@@ -0,0 +1,343 @@
+package housekeeping
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kovetskiy/lorg"
+	"github.com/reconquest/zeus/pkg/backup/operation"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/exec"
+	"github.com/reconquest/zeus/pkg/zfs/fake"
+)
+
+const (
+	testHoldTag        = "zeus"
+	testSnapshotPrefix = "zeus:"
+)
+
+func newTestOperation(t *testing.T) operation.Backup {
+	source := fake.New()
+	source.AddPool("z", true)
+
+	target := fake.New()
+	target.AddPool("zbackup", true)
+
+	for _, step := range []struct {
+		backend *fake.Backend
+		dataset string
+	}{
+		{source, "z/home"},
+		{target, "zbackup/vm/z/home"},
+	} {
+		err := step.backend.EnsureDatasetExists(step.dataset)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var backup operation.Backup
+
+	backup.Source = "z/home"
+	backup.Target = "zbackup/vm"
+	backup.TargetPoolGUID = "1234"
+	backup.Hosts.Source = source
+	backup.Hosts.Target = target
+
+	return backup
+}
+
+func createTestSnapshots(
+	t *testing.T,
+	backend *fake.Backend,
+	dataset string,
+	names ...string,
+) {
+	for _, name := range names {
+		snapshot := dataset + "@" + name
+
+		err := backend.CreateSnapshot(snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = backend.SetDatasetProperty(snapshot, constants.Managed, `yes`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func listTestSnapshots(
+	t *testing.T,
+	backend *fake.Backend,
+	dataset string,
+) []string {
+	snapshots, err := backend.ListSnapshots(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshots
+}
+
+func TestPolicyByCount_KeepsLatestSnapshots(t *testing.T) {
+	backup := newTestOperation(t)
+
+	source := backup.Hosts.Source.(*fake.Backend)
+	target := backup.Hosts.Target.(*fake.Backend)
+
+	createTestSnapshots(t, source, "z/home", "a", "b", "c", "d")
+	createTestSnapshots(t, target, "zbackup/vm/z/home", "a", "b", "c", "d")
+
+	backup.Snapshot.Current = "d"
+
+	policy := PolicyByCount{
+		KeepOnSource: 2,
+		KeepOnTarget: 3,
+		HoldTag:      testHoldTag,
+	}
+
+	err := policy.Cleanup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshots := listTestSnapshots(t, source, "z/home"); !reflect.DeepEqual(
+		snapshots,
+		[]string{"z/home@c", "z/home@d"},
+	) {
+		t.Errorf("unexpected source snapshots: %v", snapshots)
+	}
+
+	if snapshots := listTestSnapshots(
+		t,
+		target,
+		"zbackup/vm/z/home",
+	); !reflect.DeepEqual(
+		snapshots,
+		[]string{
+			"zbackup/vm/z/home@b",
+			"zbackup/vm/z/home@c",
+			"zbackup/vm/z/home@d",
+		},
+	) {
+		t.Errorf("unexpected target snapshots: %v", snapshots)
+	}
+}
+
+func TestPolicyByCount_KeepsSnapshotsSentToOtherTargets(t *testing.T) {
+	backup := newTestOperation(t)
+
+	source := backup.Hosts.Source.(*fake.Backend)
+
+	createTestSnapshots(t, source, "z/home", "a", "b", "c")
+
+	// "a" is the latest snapshot sent to another target backup pool
+	err := source.SetDatasetProperty(
+		"z/home@a",
+		constants.TargetPrefix+"5678",
+		"zoffsite",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup.Snapshot.Current = "c"
+
+	policy := PolicyByCount{
+		KeepOnSource: 1,
+		KeepOnTarget: 1,
+		HoldTag:      testHoldTag,
+	}
+
+	err = policy.Cleanup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshots := listTestSnapshots(t, source, "z/home"); !reflect.DeepEqual(
+		snapshots,
+		[]string{"z/home@a", "z/home@c"},
+	) {
+		t.Errorf("unexpected source snapshots: %v", snapshots)
+	}
+}
+
+func TestPolicyByCount_KeepsSnapshotsHeldByOtherTags(t *testing.T) {
+	backup := newTestOperation(t)
+
+	source := backup.Hosts.Source.(*fake.Backend)
+
+	createTestSnapshots(t, source, "z/home", "a", "b", "c")
+
+	for _, hold := range []struct {
+		tag      string
+		snapshot string
+	}{
+		{testHoldTag, "z/home@a"},
+		{"zeus:zoffsite", "z/home@b"},
+	} {
+		err := source.Hold(hold.tag, hold.snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backup.Snapshot.Current = "c"
+
+	policy := PolicyByCount{
+		KeepOnSource: 1,
+		KeepOnTarget: 1,
+		HoldTag:      testHoldTag,
+	}
+
+	err := policy.Cleanup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshots := listTestSnapshots(t, source, "z/home"); !reflect.DeepEqual(
+		snapshots,
+		[]string{"z/home@b", "z/home@c"},
+	) {
+		t.Errorf("unexpected source snapshots: %v", snapshots)
+	}
+}
+
+func TestPolicyByCount_CountsPlannedSnapshotInDryRun(t *testing.T) {
+	exec.SetDryRun(true)
+	defer exec.SetDryRun(false)
+
+	backup := newTestOperation(t)
+
+	source := backup.Hosts.Source.(*fake.Backend)
+
+	// current snapshot "d" is not made in dry run mode
+	createTestSnapshots(t, source, "z/home", "a", "b", "c")
+
+	backup.Snapshot.Current = "d"
+
+	policy := PolicyByCount{
+		KeepOnSource: 2,
+		KeepOnTarget: 1,
+		HoldTag:      testHoldTag,
+	}
+
+	err := policy.Cleanup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fake backend doesn't honor dry run mode, so planned destroys are made
+	if snapshots := listTestSnapshots(t, source, "z/home"); !reflect.DeepEqual(
+		snapshots,
+		[]string{"z/home@c"},
+	) {
+		t.Errorf("unexpected source snapshots: %v", snapshots)
+	}
+}
+
+func TestPolicyByAge_CleanupTarget(t *testing.T) {
+	backup := newTestOperation(t)
+
+	target := backup.Hosts.Target.(*fake.Backend)
+
+	now := time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+
+	names := []string{}
+	for _, timestamp := range []time.Time{
+		// monthly
+		time.Date(2025, 12, 10, 12, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 20, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC),
+		// weekly
+		time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC),
+		// daily
+		time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 16, 20, 0, 0, 0, time.UTC),
+		// keep all
+		time.Date(2026, 3, 18, 6, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 18, 10, 0, 0, 0, time.UTC),
+	} {
+		names = append(names, testSnapshotPrefix+timestamp.Format(time.RFC3339))
+	}
+
+	// snapshots which names are not made by zeus are kept
+	names = append(names, "manual")
+
+	createTestSnapshots(t, target, "zbackup/vm/z/home", names...)
+
+	policy := PolicyByAge{
+		KeepAll:        12 * time.Hour,
+		KeepDaily:      7,
+		KeepWeekly:     4,
+		KeepMonthly:    6,
+		HoldTag:        testHoldTag,
+		SnapshotPrefix: testSnapshotPrefix,
+	}
+
+	destroyed, err := policy.cleanupTarget(
+		lorg.NewLog(),
+		backup,
+		now,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if destroyed != 3 {
+		t.Errorf("expected 3 snapshots to be destroyed, got %d", destroyed)
+	}
+
+	kept := []string{}
+	for _, i := range []int{1, 2, 4, 6, 7, 8, 9} {
+		kept = append(kept, "zbackup/vm/z/home@"+names[i])
+	}
+
+	if snapshots := listTestSnapshots(
+		t,
+		target,
+		"zbackup/vm/z/home",
+	); !reflect.DeepEqual(snapshots, kept) {
+		t.Errorf("unexpected target snapshots: %v", snapshots)
+	}
+}
+
+func TestPolicyByAge_KeepsNewestSnapshot(t *testing.T) {
+	backup := newTestOperation(t)
+
+	target := backup.Hosts.Target.(*fake.Backend)
+
+	now := time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+
+	names := []string{
+		testSnapshotPrefix + "2024-01-01T00:00:00Z",
+		testSnapshotPrefix + "2024-02-01T00:00:00Z",
+	}
+
+	createTestSnapshots(t, target, "zbackup/vm/z/home", names...)
+
+	policy := PolicyByAge{
+		HoldTag:        testHoldTag,
+		SnapshotPrefix: testSnapshotPrefix,
+	}
+
+	_, err := policy.cleanupTarget(lorg.NewLog(), backup, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshots := listTestSnapshots(
+		t,
+		target,
+		"zbackup/vm/z/home",
+	); !reflect.DeepEqual(
+		snapshots,
+		[]string{"zbackup/vm/z/home@" + names[1]},
+	) {
+		t.Errorf("unexpected target snapshots: %v", snapshots)
+	}
+}
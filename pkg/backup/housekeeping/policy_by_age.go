@@ -0,0 +1,351 @@
+package housekeeping
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kovetskiy/lorg"
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/backup/operation"
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/duration"
+	"github.com/reconquest/zeus/pkg/text"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+// PolicyByAge keeps all snapshots made during KeepAll period and then
+// only one snapshot per day, week and month for specified amount of days,
+// weeks and months on target. Source snapshots are cleaned up by count.
+type PolicyByAge struct {
+	KeepOnSource int
+	KeepAll      time.Duration
+	KeepDaily    int
+	KeepWeekly   int
+	KeepMonthly  int
+
+	HoldTag        string
+	SnapshotPrefix string
+}
+
+type policyByAgeSnapshot struct {
+	Name string
+	Time time.Time
+}
+
+func init() {
+	Properties = append(Properties, []zfs.PropertyRequest{
+		{Name: constants.HousekeepingByAgeKeepOnSource, Inherited: true},
+		{Name: constants.HousekeepingByAgeKeepAll, Inherited: true},
+		{Name: constants.HousekeepingByAgeKeepDaily, Inherited: true},
+		{Name: constants.HousekeepingByAgeKeepWeekly, Inherited: true},
+		{Name: constants.HousekeepingByAgeKeepMonthly, Inherited: true},
+	}...)
+}
+
+func NewPolicyByAge(
+	config *config.Config,
+	properties []zfs.Property,
+) (Policy, error) {
+	var (
+		policy   PolicyByAge
+		defaults = config.Defaults.Housekeeping.ByAge
+		err      error
+	)
+
+	policy.HoldTag = config.HoldTag
+	policy.SnapshotPrefix = config.SnapshotPrefix
+
+	policy.KeepOnSource = defaults.KeepOnSource
+	policy.KeepDaily = defaults.KeepDaily
+	policy.KeepWeekly = defaults.KeepWeekly
+	policy.KeepMonthly = defaults.KeepMonthly
+
+	policy.KeepAll, err = duration.Parse(defaults.KeepAll)
+	if err != nil {
+		return nil, karma.
+			Describe("keep_all", defaults.KeepAll).
+			Format(
+				err,
+				"unable to parse default by-age housekeeping period",
+			)
+	}
+
+	for _, property := range properties {
+		switch property.Name {
+		case constants.HousekeepingByAgeKeepOnSource:
+			policy.KeepOnSource, err = parsePolicyByCountKeepProperty(
+				property.Value,
+			)
+
+		case constants.HousekeepingByAgeKeepAll:
+			policy.KeepAll, err = duration.Parse(property.Value)
+
+		case constants.HousekeepingByAgeKeepDaily:
+			policy.KeepDaily, err = parsePolicyByAgeKeepProperty(
+				property.Value,
+			)
+
+		case constants.HousekeepingByAgeKeepWeekly:
+			policy.KeepWeekly, err = parsePolicyByAgeKeepProperty(
+				property.Value,
+			)
+
+		case constants.HousekeepingByAgeKeepMonthly:
+			policy.KeepMonthly, err = parsePolicyByAgeKeepProperty(
+				property.Value,
+			)
+		}
+
+		if err != nil {
+			return nil, karma.
+				Describe("dataset", property.Source).
+				Describe("property", property.Name).
+				Describe("value", property.Value).
+				Format(
+					err,
+					"invalid housekeeping property value",
+				)
+		}
+	}
+
+	return policy, nil
+}
+
+func (PolicyByAge) GetName() string {
+	return "by-age"
+}
+
+func (policy PolicyByAge) Cleanup(operation operation.Backup) error {
+	log := operation.NewLog("{housekeeping} <by-age>")
+
+	log.Infof(
+		"configuration: will keep %d source %s; "+
+			"all target snapshots for %s, "+
+			"%d daily, %d weekly and %d monthly",
+		policy.KeepOnSource,
+		text.Pluralize("snapshot", policy.KeepOnSource),
+		policy.KeepAll,
+		policy.KeepDaily,
+		policy.KeepWeekly,
+		policy.KeepMonthly,
+	)
+
+	sourceDestroyed, err := cleanupByCount(
+		log,
+		operation.Hosts.Source,
+		policy.HoldTag,
+		operation,
+		operation.Source,
+		policy.KeepOnSource,
+	)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to cleanup snapshots on source dataset",
+		)
+	}
+
+	targetDestroyed, err := policy.cleanupTarget(
+		log,
+		operation,
+		time.Now(),
+	)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to cleanup snapshots on target dataset",
+		)
+	}
+
+	log.Infof(
+		"housekeeping completed: destroyed %d %s on source and %d %s on target",
+		sourceDestroyed, text.Pluralize("snapshot", sourceDestroyed),
+		targetDestroyed, text.Pluralize("snapshot", targetDestroyed),
+	)
+
+	return nil
+}
+
+func (policy PolicyByAge) cleanupTarget(
+	log *lorg.Log,
+	operation operation.Backup,
+	now time.Time,
+) (int, error) {
+	var (
+		host    = operation.Hosts.Target
+		dataset = fmt.Sprintf("%s/%s", operation.Target, operation.Source)
+	)
+
+	log.Infof("running snapshots cleanup for dataset %q", dataset)
+
+	names, err := listCleanupSnapshots(host, operation, dataset)
+	if err != nil {
+		return 0, err
+	}
+
+	snapshots := []policyByAgeSnapshot{}
+
+	for _, name := range names {
+		_, snapshot, err := zfs.SplitSnapshotName(name)
+		if err != nil {
+			return 0, err
+		}
+
+		timestamp, err := time.Parse(
+			time.RFC3339,
+			strings.TrimPrefix(snapshot, policy.SnapshotPrefix),
+		)
+		if err != nil {
+			log.Warningf(
+				"unable to get time of snapshot %q from its name, keeping it",
+				name,
+			)
+
+			continue
+		}
+
+		snapshots = append(snapshots, policyByAgeSnapshot{
+			Name: name,
+			Time: timestamp,
+		})
+	}
+
+	keep := policy.getKept(snapshots, now)
+
+	var destroyed int
+
+	for _, snapshot := range snapshots {
+		if reason, ok := keep[snapshot.Name]; ok {
+			log.Debugf("keeping snapshot %q: %s", snapshot.Name, reason)
+
+			continue
+		}
+
+		ok, err := destroySnapshot(
+			log,
+			host,
+			policy.HoldTag,
+			operation,
+			snapshot.Name,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		if ok {
+			destroyed++
+		}
+	}
+
+	return destroyed, nil
+}
+
+// getKept returns snapshots which should be kept along with the reason why.
+// Newest snapshot is always kept because it's required for incremental
+// backups, every period (day, week, month) keeps its newest snapshot.
+func (policy PolicyByAge) getKept(
+	snapshots []policyByAgeSnapshot,
+	now time.Time,
+) map[string]string {
+	sorted := make([]policyByAgeSnapshot, len(snapshots))
+	copy(sorted, snapshots)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	type period struct {
+		name   string
+		since  time.Time
+		bucket func(time.Time) string
+		seen   map[string]bool
+	}
+
+	periods := []period{
+		{
+			name:  "daily",
+			since: now.AddDate(0, 0, -policy.KeepDaily),
+			bucket: func(timestamp time.Time) string {
+				return timestamp.UTC().Format("2006-01-02")
+			},
+		},
+		{
+			name:  "weekly",
+			since: now.AddDate(0, 0, -7*policy.KeepWeekly),
+			bucket: func(timestamp time.Time) string {
+				year, week := timestamp.UTC().ISOWeek()
+				return fmt.Sprintf("%d-W%02d", year, week)
+			},
+		},
+		{
+			name:  "monthly",
+			since: now.AddDate(0, -policy.KeepMonthly, 0),
+			bucket: func(timestamp time.Time) string {
+				return timestamp.UTC().Format("2006-01")
+			},
+		},
+	}
+
+	keep := map[string]string{}
+
+	for i, snapshot := range sorted {
+		if i == 0 {
+			keep[snapshot.Name] = "newest snapshot"
+		}
+
+		if now.Sub(snapshot.Time) <= policy.KeepAll {
+			if _, ok := keep[snapshot.Name]; !ok {
+				keep[snapshot.Name] = fmt.Sprintf(
+					"made within last %s",
+					policy.KeepAll,
+				)
+			}
+		}
+
+		for j := range periods {
+			period := &periods[j]
+
+			if period.seen == nil {
+				period.seen = map[string]bool{}
+			}
+
+			if !snapshot.Time.After(period.since) {
+				continue
+			}
+
+			bucket := period.bucket(snapshot.Time)
+			if period.seen[bucket] {
+				continue
+			}
+
+			period.seen[bucket] = true
+
+			if _, ok := keep[snapshot.Name]; !ok {
+				keep[snapshot.Name] = fmt.Sprintf(
+					"%s snapshot for %s",
+					period.name,
+					bucket,
+				)
+			}
+		}
+	}
+
+	return keep
+}
+
+func parsePolicyByAgeKeepProperty(value string) (int, error) {
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, karma.Format(err, "unexpected non-number value")
+	}
+
+	if count < 0 {
+		return 0, fmt.Errorf("amount of snapshots should not be negative")
+	}
+
+	return count, nil
+}
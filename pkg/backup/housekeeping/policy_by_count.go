@@ -3,11 +3,14 @@ package housekeeping
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
+	"github.com/kovetskiy/lorg"
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/zeus/pkg/backup/operation"
 	"github.com/reconquest/zeus/pkg/config"
 	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/exec"
 	"github.com/reconquest/zeus/pkg/text"
 	"github.com/reconquest/zeus/pkg/zfs"
 )
@@ -71,7 +74,7 @@ func (PolicyByCount) GetName() string {
 }
 
 func (policy PolicyByCount) Cleanup(operation operation.Backup) error {
-	log := log.NewChildWithPrefix("{houseskeeping} <by-count>")
+	log := operation.NewLog("{housekeeping} <by-count>")
 
 	log.Infof(
 		"configuration: will keep %d source %s, %d target %s",
@@ -81,95 +84,14 @@ func (policy PolicyByCount) Cleanup(operation operation.Backup) error {
 		text.Pluralize("snapshot", policy.KeepOnTarget),
 	)
 
-	cleanup := func(dataset string, keep int) (int, error) {
-		log.Infof(
-			"running snapshots cleanup for dataset %q (will keep %d %s)",
-			dataset,
-			keep,
-			text.Pluralize("snapshot", keep),
-		)
-
-		snapshots, err := listManagedSnapshots(dataset)
-		if err != nil {
-			return 0, err
-		}
-
-		var destroyed int
-
-		for i, _ := range snapshots {
-			_, candidate, err := zfs.SplitSnapshotName(snapshots[i])
-			if err != nil {
-				return 0, err
-			}
-
-			log.Debugf(
-				"(%2d of %2d) checking: should snapshot %q be destroyed",
-				len(snapshots)-i,
-				len(snapshots),
-				snapshots[i],
-			)
-
-			if i >= len(snapshots)-keep {
-				log.Debugf(
-					"(%2d of %2d) stop: snapshot index <= %d",
-					len(snapshots)-i,
-					len(snapshots),
-					keep,
-				)
-				break
-			}
-
-			// doubled check that we're not deleting backup snapshot
-			if operation.Snapshot.Current == candidate {
-				continue
-			}
-
-			log.Infof("destroying old snapshot %q", snapshots[i])
-
-			held, err := zfs.HasHold(policy.HoldTag, snapshots[i])
-			if err != nil {
-				return 0, karma.Format(
-					err,
-					"unable to check that snapshot %q is held by %q tag",
-					snapshots[i],
-					policy.HoldTag,
-				)
-			}
-
-			if held {
-				log.Warningf(
-					"snapshot %q is still held by %q tag, releasing it",
-					snapshots[i],
-					policy.HoldTag,
-				)
-
-				err := zfs.Release(policy.HoldTag, snapshots[i])
-				if err != nil {
-					return 0, karma.Format(
-						err,
-						"unable to release hold tag %q on %q",
-						policy.HoldTag,
-						snapshots[i],
-					)
-				}
-			}
-
-			err = zfs.DestroyDataset(snapshots[i])
-			if err != nil {
-				return 0, karma.Format(
-					err,
-					"unabled to destroy snapshot %q during cleanup",
-					snapshots[i],
-				)
-			}
-
-			destroyed++
-		}
-
-		return destroyed, nil
-	}
-
-	sourceDestroyed, err := cleanup(operation.Source, policy.KeepOnSource)
+	sourceDestroyed, err := cleanupByCount(
+		log,
+		operation.Hosts.Source,
+		policy.HoldTag,
+		operation,
+		operation.Source,
+		policy.KeepOnSource,
+	)
 	if err != nil {
 		return karma.Format(
 			err,
@@ -177,7 +99,11 @@ func (policy PolicyByCount) Cleanup(operation operation.Backup) error {
 		)
 	}
 
-	targetDestroyed, err := cleanup(
+	targetDestroyed, err := cleanupByCount(
+		log,
+		operation.Hosts.Target,
+		policy.HoldTag,
+		operation,
 		fmt.Sprintf("%s/%s", operation.Target, operation.Source),
 		policy.KeepOnTarget,
 	)
@@ -197,8 +123,91 @@ func (policy PolicyByCount) Cleanup(operation operation.Backup) error {
 	return nil
 }
 
-func listManagedSnapshots(dataset string) ([]string, error) {
-	properties, err := zfs.GetDatasetProperties([]zfs.PropertyRequest{
+// cleanupByCount destroys managed snapshots of given dataset except given
+// amount of latest ones and ones which are latest sent to any target backup
+// pool.
+func cleanupByCount(
+	log *lorg.Log,
+	host zfs.Backend,
+	holdTag string,
+	operation operation.Backup,
+	dataset string,
+	keep int,
+) (int, error) {
+	log.Infof(
+		"running snapshots cleanup for dataset %q (will keep %d %s)",
+		dataset,
+		keep,
+		text.Pluralize("snapshot", keep),
+	)
+
+	snapshots, err := listCleanupSnapshots(host, operation, dataset)
+	if err != nil {
+		return 0, err
+	}
+
+	sent, err := getSentSnapshots(host, dataset)
+	if err != nil {
+		return 0, karma.Format(
+			err,
+			"unable to get snapshots sent to target backup pools",
+		)
+	}
+
+	var destroyed int
+
+	for i, _ := range snapshots {
+		log.Debugf(
+			"(%2d of %2d) checking: should snapshot %q be destroyed",
+			len(snapshots)-i,
+			len(snapshots),
+			snapshots[i],
+		)
+
+		if i >= len(snapshots)-keep {
+			log.Debugf(
+				"(%2d of %2d) stop: snapshot index <= %d",
+				len(snapshots)-i,
+				len(snapshots),
+				keep,
+			)
+			break
+		}
+
+		if targets, ok := sent[snapshots[i]]; ok {
+			log.Infof(
+				"keeping old snapshot %q because it's the latest snapshot "+
+					"sent to %s, it's required for incremental backup",
+				snapshots[i],
+				strings.Join(targets, ", "),
+			)
+
+			continue
+		}
+
+		ok, err := destroySnapshot(
+			log,
+			host,
+			holdTag,
+			operation,
+			snapshots[i],
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		destroyed++
+	}
+
+	return destroyed, nil
+}
+
+func listManagedSnapshots(host zfs.Backend, dataset string) ([]string, error) {
+	properties, err := host.GetDatasetProperties([]zfs.PropertyRequest{
 		{Name: constants.Managed, Snapshot: true, Local: true},
 	}, dataset)
 	if err != nil {
@@ -214,6 +223,34 @@ func listManagedSnapshots(dataset string) ([]string, error) {
 	return snapshots, nil
 }
 
+// listCleanupSnapshots lists managed snapshots of given dataset for cleanup.
+// Snapshot of backup operation is not made in dry run mode, so it's counted
+// as present, otherwise the plan would keep one old snapshot less.
+func listCleanupSnapshots(
+	host zfs.Backend,
+	operation operation.Backup,
+	dataset string,
+) ([]string, error) {
+	snapshots, err := listManagedSnapshots(host, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exec.IsDryRun() {
+		return snapshots, nil
+	}
+
+	current := fmt.Sprintf("%s@%s", dataset, operation.Snapshot.Current)
+
+	for _, snapshot := range snapshots {
+		if snapshot == current {
+			return snapshots, nil
+		}
+	}
+
+	return append(snapshots, current), nil
+}
+
 func parsePolicyByCountKeepProperty(value string) (int, error) {
 	count, err := strconv.Atoi(value)
 	if err != nil {
@@ -20,6 +20,8 @@ func (PolicyNone) GetName() string {
 }
 
 func (PolicyNone) Cleanup(operation operation.Backup) error {
+	log := operation.NewLog("{housekeeping}")
+
 	log.Warningf(
 		strings.Join([]string{
 			"housekeeping for source dataset %q is disabled",
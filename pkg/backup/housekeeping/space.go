@@ -0,0 +1,167 @@
+package housekeeping
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/backup/operation"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/formatting"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+type spaceCandidate struct {
+	snapshot  string
+	createTXG uint64
+	used      uint64
+	operation operation.Backup
+}
+
+// FreeTarget destroys the oldest snapshots in target datasets of given
+// operations regardless of their housekeeping policies until given amount of
+// space is expected to be available. The latest snapshot of every target
+// dataset is kept, since it's incremental base for the next backup. Space of
+// destroyed snapshots is freed asynchronously, so expected amount of
+// available space is returned.
+func FreeTarget(
+	holdTag string,
+	operations []operation.Backup,
+	available uint64,
+	required uint64,
+) (uint64, error) {
+	candidates := []spaceCandidate{}
+
+	for _, operation := range operations {
+		snapshots, err := listSpaceCandidates(operation)
+		if err != nil {
+			return available, karma.Format(
+				err,
+				"unable to list snapshots on target dataset",
+			)
+		}
+
+		candidates = append(candidates, snapshots...)
+	}
+
+	// createtxg grows across the whole pool, so it orders snapshots of
+	// different datasets as well
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].createTXG < candidates[j].createTXG
+	})
+
+	for _, candidate := range candidates {
+		if available >= required {
+			break
+		}
+
+		log := candidate.operation.NewLog("{housekeeping} <space>")
+
+		ok, err := destroySnapshot(
+			log,
+			candidate.operation.Hosts.Target,
+			holdTag,
+			candidate.operation,
+			candidate.snapshot,
+		)
+		if err != nil {
+			return available, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		available += candidate.used
+
+		log.Infof(
+			"destroyed snapshot %q to free space on target, "+
+				"%s is expected to be available",
+			candidate.snapshot,
+			formatting.Size(available),
+		)
+	}
+
+	return available, nil
+}
+
+// listSpaceCandidates returns managed snapshots of target dataset of given
+// operation except the latest one.
+func listSpaceCandidates(
+	operation operation.Backup,
+) ([]spaceCandidate, error) {
+	dataset := fmt.Sprintf("%s/%s", operation.Target, operation.Source)
+
+	exists, err := operation.Hosts.Target.IsDatasetExists(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	mappings, err := operation.Hosts.Target.GetDatasetProperties(
+		[]zfs.PropertyRequest{
+			{Name: constants.Managed, Snapshot: true, Local: true},
+			{Name: constants.CreateTXG, Snapshot: true, System: true},
+			{Name: constants.Used, Snapshot: true, System: true},
+		},
+		dataset,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []spaceCandidate{}
+
+	for _, mapping := range mappings {
+		var (
+			candidate = spaceCandidate{
+				snapshot:  mapping.Source,
+				operation: operation,
+			}
+			managed bool
+		)
+
+		for _, property := range mapping.Properties {
+			switch property.Name {
+			case constants.Managed:
+				managed = true
+
+			case constants.CreateTXG, constants.Used:
+				value, err := strconv.ParseUint(property.Value, 10, 64)
+				if err != nil {
+					return nil, karma.
+						Describe("snapshot", mapping.Source).
+						Describe("property", property.Name).
+						Format(
+							err,
+							"unable to parse snapshot property value",
+						)
+				}
+
+				if property.Name == constants.CreateTXG {
+					candidate.createTXG = value
+				} else {
+					candidate.used = value
+				}
+			}
+		}
+
+		if managed {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].createTXG < candidates[j].createTXG
+	})
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	return candidates[:len(candidates)-1], nil
+}
@@ -0,0 +1,49 @@
+package housekeeping
+
+import (
+	"strings"
+
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+// getSentSnapshots returns latest snapshots of given dataset which have been
+// sent to any known target backup pool along with target datasets. Those
+// snapshots are incremental bases for next backups to those pools, so they
+// should never be destroyed.
+func getSentSnapshots(
+	host zfs.Backend,
+	dataset string,
+) (map[string][]string, error) {
+	mappings, err := host.GetDatasetProperties([]zfs.PropertyRequest{
+		{Name: "all", Snapshot: true, Local: true},
+	}, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	// snapshots are listed from oldest to newest, so the latest snapshot
+	// sent to every pool wins
+	latest := map[string]zfs.Property{}
+
+	for _, mapping := range mappings {
+		for _, property := range mapping.Properties {
+			if !strings.HasPrefix(property.Name, constants.TargetPrefix) {
+				continue
+			}
+
+			latest[property.Name] = property
+		}
+	}
+
+	snapshots := map[string][]string{}
+
+	for _, property := range latest {
+		snapshots[property.Source] = append(
+			snapshots[property.Source],
+			property.Value,
+		)
+	}
+
+	return snapshots, nil
+}
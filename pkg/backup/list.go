@@ -0,0 +1,297 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/formatting"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+type (
+	OptFormat string
+)
+
+type (
+	listedFilesystem struct {
+		Source    string           `json:"source"`
+		Namespace string           `json:"namespace"`
+		Dataset   string           `json:"dataset"`
+		Used      uint64           `json:"used"`
+		Held      int              `json:"held"`
+		Oldest    *time.Time       `json:"oldest"`
+		Newest    *time.Time       `json:"newest"`
+		Snapshots []listedSnapshot `json:"snapshots"`
+	}
+
+	listedSnapshot struct {
+		Name string     `json:"name"`
+		Time *time.Time `json:"time"`
+		Used uint64     `json:"used"`
+		Held bool       `json:"held"`
+	}
+)
+
+func List(config *config.Config, opts ...Opts) error {
+	var (
+		noExport bool
+		format   = "text"
+		backend  OptBackend
+	)
+
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case OptNoExport:
+			noExport = bool(opt)
+		case OptFormat:
+			format = string(opt)
+		case OptBackend:
+			backend = opt
+		}
+	}
+
+	switch format {
+	case "text", "json":
+		// ok
+	default:
+		return karma.
+			Describe("format", format).
+			Reason(
+				"unsupported output format, supported formats are: " +
+					"'text', 'json'",
+			)
+	}
+
+	_, target := getBackends(config, backend)
+
+	config, err := getAvailableTarget(config, target)
+	if err != nil {
+		return err
+	}
+
+	targetDatasetName := fmt.Sprintf(
+		"%s/%s",
+		config.TargetPool,
+		config.TargetDataset,
+	)
+
+	if isPoolManaged(config, target) {
+		imported, err := importPool(target, config.TargetPool)
+		if err != nil {
+			return err
+		}
+
+		if imported && !noExport {
+			defer exportPool(target, config.TargetPool)
+		}
+	} else {
+		err := requirePoolImported(target, config.TargetPool)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("listing snapshots on the backup dataset %q", targetDatasetName)
+
+	filesystems, err := getListedFilesystems(config, target, targetDatasetName)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to list backups in backup dataset",
+		)
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(filesystems)
+
+	default:
+		return printListedFilesystems(filesystems)
+	}
+}
+
+func getListedFilesystems(
+	config *config.Config,
+	target zfs.Backend,
+	targetDatasetName string,
+) ([]*listedFilesystem, error) {
+	mappings, err := target.GetDatasetPropertiesRecursive(
+		[]zfs.PropertyRequest{
+			{
+				Name:       constants.Used,
+				System:     true,
+				Filesystem: true,
+				Volume:     true,
+				Snapshot:   true,
+			},
+			{Name: constants.UserRefs, System: true, Snapshot: true},
+		},
+		targetDatasetName,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		filesystems = []*listedFilesystem{}
+		index       = map[string]*listedFilesystem{}
+	)
+
+	get := func(dataset string) *listedFilesystem {
+		filesystem, ok := index[dataset]
+		if !ok {
+			filesystem = &listedFilesystem{
+				Dataset:   dataset,
+				Snapshots: []listedSnapshot{},
+			}
+
+			index[dataset] = filesystem
+		}
+
+		return filesystem
+	}
+
+	for _, mapping := range mappings {
+		var (
+			dataset  = mapping.Source
+			snapshot string
+		)
+
+		if strings.Contains(mapping.Source, "@") {
+			dataset, snapshot, err = zfs.SplitSnapshotName(mapping.Source)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		namespace, source, ok := parseTargetDataset(targetDatasetName, dataset)
+		if !ok {
+			continue
+		}
+
+		filesystem := get(dataset)
+		filesystem.Namespace = namespace
+		filesystem.Source = source
+
+		var used, userrefs uint64
+
+		for _, property := range mapping.Properties {
+			value, err := strconv.ParseUint(property.Value, 10, 64)
+			if err != nil {
+				return nil, karma.
+					Describe("dataset", mapping.Source).
+					Describe("property", property.Name).
+					Format(
+						err,
+						"unable to parse property value",
+					)
+			}
+
+			switch property.Name {
+			case constants.Used:
+				used = value
+			case constants.UserRefs:
+				userrefs = value
+			}
+		}
+
+		if snapshot == "" {
+			filesystem.Used = used
+
+			continue
+		}
+
+		listed := listedSnapshot{
+			Name: snapshot,
+			Used: used,
+			Held: userrefs > 0,
+		}
+
+		if strings.HasPrefix(snapshot, config.SnapshotPrefix) {
+			timestamp, err := time.Parse(
+				time.RFC3339,
+				strings.TrimPrefix(snapshot, config.SnapshotPrefix),
+			)
+			if err == nil {
+				listed.Time = &timestamp
+			}
+		}
+
+		if listed.Held {
+			filesystem.Held++
+		}
+
+		if listed.Time != nil {
+			oldest, newest := filesystem.Oldest, filesystem.Newest
+
+			if oldest == nil || listed.Time.Before(*oldest) {
+				filesystem.Oldest = listed.Time
+			}
+
+			if newest == nil || listed.Time.After(*newest) {
+				filesystem.Newest = listed.Time
+			}
+		}
+
+		filesystem.Snapshots = append(filesystem.Snapshots, listed)
+
+		if len(filesystem.Snapshots) == 1 {
+			filesystems = append(filesystems, filesystem)
+		}
+	}
+
+	sort.SliceStable(filesystems, func(i, j int) bool {
+		if filesystems[i].Source != filesystems[j].Source {
+			return filesystems[i].Source < filesystems[j].Source
+		}
+
+		return filesystems[i].Namespace < filesystems[j].Namespace
+	})
+
+	return filesystems, nil
+}
+
+func printListedFilesystems(filesystems []*listedFilesystem) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(
+		writer,
+		"SOURCE\tNAMESPACE\tSNAPSHOTS\tOLDEST\tNEWEST\tHELD\tUSED",
+	)
+
+	formatTime := func(timestamp *time.Time) string {
+		if timestamp == nil {
+			return "-"
+		}
+
+		return timestamp.Local().Format("2006-01-02 15:04:05")
+	}
+
+	for _, filesystem := range filesystems {
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%d\t%s\t%s\t%d\t%s\n",
+			filesystem.Source,
+			filesystem.Namespace,
+			len(filesystem.Snapshots),
+			formatTime(filesystem.Oldest),
+			formatTime(filesystem.Newest),
+			filesystem.Held,
+			formatting.Size(filesystem.Used),
+		)
+	}
+
+	return writer.Flush()
+}
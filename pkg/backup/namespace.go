@@ -0,0 +1,189 @@
+package backup
+
+import (
+	"strings"
+	"time"
+
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+const (
+	namespacePrefix = "guid:"
+
+	// chainSeparator separates namespace of the source dataset from time
+	// when new incremental chain has been started because previous one was
+	// broken.
+	chainSeparator = ".chain:"
+)
+
+type targetSnapshot struct {
+	Dataset   string
+	Namespace string
+	Source    string
+	Name      string
+}
+
+// getNamespace returns name of the target dataset which will hold backups
+// of the source dataset with given GUID.
+func getNamespace(guid string) string {
+	return namespacePrefix + guid[len(guid)-7:]
+}
+
+// getNewChainNamespace returns name of the target dataset which will hold
+// backups of the source dataset with given GUID if its incremental chain is
+// broken, so new chain is started by full send at given time.
+func getNewChainNamespace(guid string, now time.Time) string {
+	return getNamespace(guid) + chainSeparator + now.Format(time.RFC3339)
+}
+
+// getCurrentNamespace returns namespace of the latest incremental chain of
+// given source dataset among given target datasets, which are named
+// relatively to target backup dataset.
+func getCurrentNamespace(
+	guid string,
+	source string,
+	datasets []string,
+) string {
+	var (
+		namespace = getNamespace(guid)
+		current   = namespace
+	)
+
+	for _, dataset := range datasets {
+		parts := strings.SplitN(dataset, "/", 2)
+		if len(parts) != 2 || parts[1] != source {
+			continue
+		}
+
+		// chain start time is RFC3339 in UTC, so names can be compared
+		// lexicographically
+		if strings.HasPrefix(parts[0], namespace+chainSeparator) &&
+			parts[0] > current {
+			current = parts[0]
+		}
+	}
+
+	return current
+}
+
+// listTargetDatasets returns names of filesystems and volumes located under
+// given target dataset relatively to it. Chain dataset which first full
+// receive has been interrupted has no snapshots, but it's listed, so the
+// receive is resumed instead of starting yet another chain.
+func listTargetDatasets(
+	host zfs.Backend,
+	targetDatasetName string,
+) ([]string, error) {
+	// target dataset is not created in dry run mode
+	exists, err := host.IsDatasetExists(targetDatasetName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	mappings, err := host.GetDatasetPropertiesRecursive(
+		[]zfs.PropertyRequest{
+			{
+				Name:       constants.GUID,
+				System:     true,
+				Filesystem: true,
+				Volume:     true,
+			},
+		},
+		targetDatasetName,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	datasets := []string{}
+
+	for _, mapping := range mappings {
+		if !strings.HasPrefix(mapping.Source, targetDatasetName+"/") {
+			continue
+		}
+
+		datasets = append(
+			datasets,
+			strings.TrimPrefix(mapping.Source, targetDatasetName+"/"),
+		)
+	}
+
+	return datasets, nil
+}
+
+// getSnapshotDatasets returns names of target datasets which have snapshots
+// made by zeus.
+func getSnapshotDatasets(
+	targetSnapshotsBySource map[string]latestSnapshot,
+) []string {
+	datasets := []string{}
+
+	for dataset := range targetSnapshotsBySource {
+		datasets = append(datasets, dataset)
+	}
+
+	return datasets
+}
+
+// listTargetSnapshots returns all snapshots stored in namespaces of given
+// target dataset along with original source dataset names.
+func listTargetSnapshots(
+	host zfs.Backend,
+	targetDatasetName string,
+) ([]targetSnapshot, error) {
+	names, err := host.ListSnapshots(targetDatasetName)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []targetSnapshot{}
+
+	for _, name := range names {
+		dataset, snapshot, err := zfs.SplitSnapshotName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		namespace, source, ok := parseTargetDataset(targetDatasetName, dataset)
+		if !ok {
+			continue
+		}
+
+		snapshots = append(snapshots, targetSnapshot{
+			Dataset:   dataset,
+			Namespace: namespace,
+			Source:    source,
+			Name:      snapshot,
+		})
+	}
+
+	return snapshots, nil
+}
+
+// parseTargetDataset splits name of the dataset located under target backup
+// dataset into namespace and original source dataset name.
+func parseTargetDataset(
+	targetDatasetName string,
+	dataset string,
+) (string, string, bool) {
+	if !strings.HasPrefix(dataset, targetDatasetName+"/") {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(
+		strings.TrimPrefix(dataset, targetDatasetName+"/"),
+		"/",
+		2,
+	)
+
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], namespacePrefix) {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
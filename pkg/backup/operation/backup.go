@@ -3,11 +3,13 @@ package operation
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/kovetskiy/lorg"
 	"github.com/reconquest/karma-go"
 	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/exec"
 	"github.com/reconquest/zeus/pkg/formatting"
 	pkg_log "github.com/reconquest/zeus/pkg/log"
 	"github.com/reconquest/zeus/pkg/zfs"
@@ -15,33 +17,253 @@ import (
 
 type (
 	Backup struct {
-		Enabled bool
-		GUID    string
-		Source  string
-		Target  string
+		Enabled  bool
+		Interval time.Duration
+		GUID     string
+		Source   string
+		Target   string
+
+		// TargetPoolGUID is used to mark source snapshots which have been
+		// sent to the target backup pool.
+		TargetPoolGUID string
+
+		// RateLimit limits rate of the dataset send stream in addition to
+		// RateLimiter, which limits total rate of all send streams.
+		RateLimit   zfs.RateLimit
+		RateLimiter *zfs.RateLimiter
+		BufferSize  uint64
+		Compression zfs.Compression
+
+		// Raw makes encrypted dataset to be sent as is, so target doesn't
+		// need its key.
+		Raw bool
+
+		// Recursive makes snapshot of the source dataset along with all its
+		// descendants atomically and sends them as replication stream.
+		Recursive bool
+
+		// Volume is set if source dataset is a volume.
+		Volume bool
+
+		Flags Flags
+
+		// OnBrokenChain specifies what to do if incremental chain is broken,
+		// NewChainTarget is used instead of Target if new chain is started.
+		OnBrokenChain  string
+		NewChainTarget string
 
 		Snapshot struct {
 			Current string
 			Base    string
+
+			// BaseGUID is guid of the base snapshot on target, which is
+			// used to find the base snapshot on source regardless of name.
+			BaseGUID string
+
+			// Made is set if current snapshot has been made by Estimate,
+			// it's destroyed by Discard if operation is not run.
+			Made bool
+		}
+
+		Hosts struct {
+			Source zfs.Backend
+			Target zfs.Backend
 		}
 	}
-)
 
-var (
-	log = pkg_log.NewChildWithPrefix("{backup}")
+	// Flags are additional flags of zfs send and zfs recv.
+	Flags struct {
+		Send        []string
+		RecvExclude []string
+		RecvSet     []string
+	}
 )
 
-func (operation Backup) Run() error {
-	err := operation.ensureTargetDataset()
+// NewLog returns logger which prefixes messages with source dataset name, so
+// output of concurrently running operations can be told apart.
+func (operation Backup) NewLog(prefix string) *lorg.Log {
+	return pkg_log.NewChildWithPrefix(
+		fmt.Sprintf("%s [%s]", prefix, operation.Source),
+	)
+}
+
+// Run sends current snapshot of the source dataset to the target. If
+// previous receive into the target has been interrupted, it is resumed
+// instead and operation snapshots are updated to match resumed ones.
+func (operation *Backup) Run() error {
+	log := operation.NewLog("{backup}")
+
+	err := operation.ensureTargetDataset(log)
 	if err != nil {
 		return err
 	}
 
-	log.Debugf(
-		"creating snapshot %q on dataset %q",
-		operation.Snapshot.Current,
-		operation.Source,
-	)
+	targetDataset := fmt.Sprintf("%s/%s", operation.Target, operation.Source)
+
+	resumeToken, err := operation.Hosts.Target.GetResumeToken(targetDataset)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to check target dataset for interrupted receive",
+		)
+	}
+
+	if resumeToken != "" {
+		err = operation.resume(log, targetDataset, resumeToken)
+	} else {
+		err = operation.checkChain(log, targetDataset)
+		if err != nil {
+			return err
+		}
+
+		// target is changed if new chain is started
+		targetDataset = fmt.Sprintf(
+			"%s/%s",
+			operation.Target,
+			operation.Source,
+		)
+
+		err = operation.send(log)
+	}
+	if err != nil {
+		return err
+	}
+
+	targetSnapshots := []string{
+		fmt.Sprintf("%s@%s", targetDataset, operation.Snapshot.Current),
+	}
+
+	// snapshots of descendants are marked as well, so they are not reported
+	// as snapshots which are not made by zeus
+	if operation.Recursive && !exec.IsDryRun() {
+		targetSnapshots, err = zfs.ListSnapshotTree(
+			operation.Hosts.Target,
+			targetSnapshots[0],
+		)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to list snapshots of target descendants",
+			)
+		}
+	}
+
+	for _, snapshot := range targetSnapshots {
+		err = operation.Hosts.Target.SetDatasetProperty(
+			snapshot,
+			constants.Managed,
+			`yes`,
+		)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unabled to set managed mark on target snapshot",
+			)
+		}
+	}
+
+	if operation.Raw {
+		err = operation.Hosts.Target.SetDatasetProperty(
+			fmt.Sprintf("%s@%s", targetDataset, operation.Snapshot.Current),
+			constants.Raw,
+			`yes`,
+		)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to set raw mark on target snapshot",
+			)
+		}
+	}
+
+	if operation.Recursive {
+		err = operation.Hosts.Target.SetDatasetProperty(
+			fmt.Sprintf("%s@%s", targetDataset, operation.Snapshot.Current),
+			constants.Recursive,
+			`yes`,
+		)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to set recursive mark on target snapshot",
+			)
+		}
+	}
+
+	if operation.TargetPoolGUID != "" {
+		err = operation.Hosts.Source.SetDatasetProperty(
+			fmt.Sprintf("%s@%s", operation.Source, operation.Snapshot.Current),
+			constants.TargetPrefix+operation.TargetPoolGUID,
+			filepath.Dir(operation.Target),
+		)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to set target mark on source snapshot",
+			)
+		}
+	}
+
+	return nil
+}
+
+// Estimate returns expected size of the stream which will be sent by Run as
+// estimated by zfs send -nvP. Current snapshot is made to be estimated
+// unless it already exists, Discard should be called to destroy it if
+// operation is not run afterwards. Nothing is created on target. Snapshots
+// are not made in dry run mode, so amount of data written since incremental
+// base is used as size of the stream then.
+func (operation *Backup) Estimate() (uint64, error) {
+	targetDataset := fmt.Sprintf("%s/%s", operation.Target, operation.Source)
+
+	resumeToken, err := operation.Hosts.Target.GetResumeToken(targetDataset)
+	if err != nil {
+		return 0, karma.Format(
+			err,
+			"unable to check target dataset for interrupted receive",
+		)
+	}
+
+	if resumeToken != "" {
+		estimate, err := operation.Hosts.Source.EstimateSend(
+			`-t`,
+			resumeToken,
+		)
+		if err != nil {
+			return 0, karma.Format(
+				err,
+				"unable to estimate size of resumed send",
+			)
+		}
+
+		return estimate.Size, nil
+	}
+
+	required, err := operation.isChainRequired(targetDataset)
+	if err != nil {
+		return 0, err
+	}
+
+	var base string
+
+	if required {
+		chain, err := operation.getChainBase(targetDataset)
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case chain.Broken == "":
+			base = chain.Name
+
+		// new chain is started by full send
+		case operation.OnBrokenChain != OnBrokenChainFull:
+			return 0, operation.getBrokenChainError(
+				targetDataset,
+				chain.Broken,
+			)
+		}
+	}
 
 	sourceSnapshot := fmt.Sprintf(
 		"%s@%s",
@@ -49,49 +271,164 @@ func (operation Backup) Run() error {
 		operation.Snapshot.Current,
 	)
 
-	err = zfs.CreateSnapshot(sourceSnapshot)
+	exists, err := operation.Hosts.Source.IsDatasetExists(sourceSnapshot)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if !exists {
+		if exec.IsDryRun() {
+			return zfs.EstimateNewSnapshot(
+				operation.Hosts.Source,
+				operation.Source,
+				base,
+				operation.Recursive,
+			)
+		}
+
+		if operation.Recursive {
+			err = operation.Hosts.Source.CreateSnapshotRecursive(
+				sourceSnapshot,
+			)
+		} else {
+			err = operation.Hosts.Source.CreateSnapshot(sourceSnapshot)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		operation.Snapshot.Made = true
 	}
 
-	err = zfs.SetDatasetProperty(sourceSnapshot, constants.Managed, `yes`)
+	var baseSnapshot string
+
+	if base != "" {
+		baseSnapshot = fmt.Sprintf("%s@%s", operation.Source, base)
+	}
+
+	size, err := zfs.EstimateCopyDataset(
+		operation.Hosts.Source,
+		sourceSnapshot,
+		baseSnapshot,
+		operation.getCopyOpts()...,
+	)
 	if err != nil {
-		return karma.Format(
-			err,
-			"unable to set managed mark on source snapshot",
+		discardErr := operation.Discard()
+		if discardErr != nil {
+			operation.NewLog("{backup}").Error(discardErr)
+		}
+
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// Discard destroys current snapshot if it has been made by Estimate, so
+// operation which is not run doesn't leave it behind.
+func (operation *Backup) Discard() error {
+	if !operation.Snapshot.Made {
+		return nil
+	}
+
+	snapshots := []string{
+		fmt.Sprintf("%s@%s", operation.Source, operation.Snapshot.Current),
+	}
+
+	if operation.Recursive {
+		var err error
+
+		snapshots, err = zfs.ListSnapshotTree(
+			operation.Hosts.Source,
+			snapshots[0],
 		)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to list snapshots of source descendants",
+			)
+		}
 	}
 
-	if operation.Snapshot.Base != "" {
-		log.Infof(
-			"starting incremental send: %q..%q -> %q",
-			fmt.Sprintf("%s@%s", operation.Source, operation.Snapshot.Base),
-			fmt.Sprintf("%s@%s", operation.Source, operation.Snapshot.Current),
+	for _, snapshot := range snapshots {
+		err := operation.Hosts.Source.DestroyDataset(snapshot)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to destroy estimated snapshot %q",
+				snapshot,
+			)
+		}
+	}
+
+	operation.Snapshot.Made = false
+
+	return nil
+}
+
+func (operation *Backup) send(log *lorg.Log) error {
+	sourceSnapshot, err := operation.createSnapshot(log)
+	if err != nil {
+		return err
+	}
+
+	var (
+		baseSnapshot  string
+		targetDataset = fmt.Sprintf("%s/%s", operation.Target, sourceSnapshot)
+	)
+
+	// replication stream is received into filesystem, since it contains
+	// snapshots of descendants as well
+	if operation.Recursive {
+		targetDataset = fmt.Sprintf(
+			"%s/%s",
 			operation.Target,
+			operation.Source,
 		)
+	}
 
-		operation.Snapshot.Base = fmt.Sprintf(
+	if operation.Snapshot.Base != "" {
+		baseSnapshot = fmt.Sprintf(
 			"%s@%s",
 			operation.Source,
 			operation.Snapshot.Base,
 		)
+
+		log.Infof(
+			"starting incremental%s send: %q..%q -> %q",
+			operation.getSendMode(),
+			baseSnapshot,
+			sourceSnapshot,
+			operation.Target,
+		)
+
+		if operation.Recursive && !exec.IsDryRun() {
+			err = operation.logDescendantChanges(log)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		log.Infof(
-			"starting full send: %q -> %q",
-			fmt.Sprintf("%s@%s", operation.Source, operation.Snapshot.Current),
+			"starting full%s send: %q -> %q",
+			operation.getSendMode(),
+			sourceSnapshot,
 			operation.Target,
 		)
 	}
 
 	err = zfs.CopyDataset(
+		operation.Hosts.Source,
+		operation.Hosts.Target,
 		sourceSnapshot,
-		operation.Target,
-		operation.Snapshot.Base,
-		createCopyProgressLogger(
-			log.NewChildWithPrefix(
+		targetDataset,
+		baseSnapshot,
+		CreateCopyProgressLogger(
+			operation.NewLog(
 				fmt.Sprintf("{zfs send} sending %s:", sourceSnapshot),
 			),
 		),
+		operation.getCopyOpts()...,
 	)
 	if err != nil {
 		return karma.
@@ -103,29 +440,301 @@ func (operation Backup) Run() error {
 			)
 	}
 
-	err = zfs.SetDatasetProperty(
-		fmt.Sprintf("%s/%s", operation.Target, sourceSnapshot),
+	return nil
+}
+
+// createSnapshot creates current snapshot of the source dataset unless it
+// already exists and returns its full name.
+func (operation *Backup) createSnapshot(log *lorg.Log) (string, error) {
+	log.Debugf(
+		"creating snapshot %q on dataset %q",
+		operation.Snapshot.Current,
+		operation.Source,
+	)
+
+	sourceSnapshot := fmt.Sprintf(
+		"%s@%s",
+		operation.Source,
+		operation.Snapshot.Current,
+	)
+
+	// snapshot can be already made by backup to another target
+	exists, err := operation.Hosts.Source.IsDatasetExists(sourceSnapshot)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		if operation.Recursive {
+			err = operation.Hosts.Source.CreateSnapshotRecursive(
+				sourceSnapshot,
+			)
+		} else {
+			err = operation.Hosts.Source.CreateSnapshot(sourceSnapshot)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = operation.Hosts.Source.SetDatasetProperty(
+		sourceSnapshot,
 		constants.Managed,
 		`yes`,
 	)
+	if err != nil {
+		return "", karma.Format(
+			err,
+			"unable to set managed mark on source snapshot",
+		)
+	}
+
+	return sourceSnapshot, nil
+}
+
+func (operation *Backup) getCopyOpts() []zfs.CopyOpts {
+	return []zfs.CopyOpts{
+		zfs.CopyOptRateLimit(operation.RateLimit),
+		zfs.CopyOptRateLimiter(operation.RateLimiter),
+		zfs.CopyOptBufferSize(operation.BufferSize),
+		zfs.CopyOptCompression(operation.Compression),
+		zfs.CopyOptRaw(operation.Raw),
+		zfs.CopyOptSendFlags(operation.Flags.Send),
+		zfs.CopyOptRecvFlags(operation.getRecvFlags()),
+		zfs.CopyOptRecursive(operation.Recursive),
+	}
+}
+
+// logDescendantChanges reports descendants which have been created since the
+// base snapshot, they are sent in full, and descendants which have been
+// destroyed, they are kept on the target as is.
+func (operation *Backup) logDescendantChanges(log *lorg.Log) error {
+	source, err := zfs.ListSnapshotTree(
+		operation.Hosts.Source,
+		fmt.Sprintf("%s@%s", operation.Source, operation.Snapshot.Current),
+	)
 	if err != nil {
 		return karma.Format(
 			err,
-			"unabled to set managed mark on target snapshot",
+			"unable to list snapshots of source descendants",
 		)
 	}
 
+	targetDataset := fmt.Sprintf("%s/%s", operation.Target, operation.Source)
+
+	target, err := zfs.ListSnapshotTree(
+		operation.Hosts.Target,
+		fmt.Sprintf("%s@%s", targetDataset, operation.Snapshot.Base),
+	)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to list snapshots of target descendants",
+		)
+	}
+
+	var (
+		sourceDescendants = getDescendants(operation.Source, source)
+		targetDescendants = getDescendants(targetDataset, target)
+	)
+
+	for _, descendant := range sourceDescendants {
+		if !contains(targetDescendants, descendant) {
+			log.Infof(
+				"dataset %q has been created since %q, it will be sent in full",
+				operation.Source+descendant,
+				operation.Snapshot.Base,
+			)
+		}
+	}
+
+	for _, descendant := range targetDescendants {
+		if !contains(sourceDescendants, descendant) {
+			log.Warningf(
+				"dataset %q has been destroyed since %q, "+
+					"its backup %q is kept on target",
+				operation.Source+descendant,
+				operation.Snapshot.Base,
+				targetDataset+descendant,
+			)
+		}
+	}
+
 	return nil
 }
 
-func (operation *Backup) ensureTargetDataset() error {
+// getDescendants returns names of filesystems which given snapshots belong
+// to relative to given root filesystem.
+func getDescendants(root string, snapshots []string) []string {
+	descendants := []string{}
+
+	for _, snapshot := range snapshots {
+		filesystem := strings.SplitN(snapshot, "@", 2)[0]
+		if filesystem == root {
+			continue
+		}
+
+		descendants = append(
+			descendants,
+			strings.TrimPrefix(filesystem, root),
+		)
+	}
+
+	return descendants
+}
+
+func contains(list []string, item string) bool {
+	for _, candidate := range list {
+		if candidate == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (operation *Backup) getRecvFlags() []string {
+	flags := append(
+		append([]string{}, operation.Flags.RecvExclude...),
+		operation.Flags.RecvSet...,
+	)
+
+	// replication stream contains properties of datasets, so received
+	// datasets would be mounted over source ones once backup pool is
+	// imported
+	if operation.Recursive && !hasRecvProperty(flags, constants.Mountpoint) {
+		flags = append(flags, `-x`, constants.Mountpoint)
+	}
+
+	// backup volumes should not be exposed as block devices on the target
+	// host, volmode is inherited, so it covers volumes in the replication
+	// stream as well
+	if (operation.Volume || operation.Recursive) &&
+		!hasRecvProperty(flags, constants.Volmode) {
+		flags = append(flags, `-o`, constants.Volmode+`=none`)
+	}
+
+	return flags
+}
+
+// hasRecvProperty reports whether given property is excluded or set by
+// given zfs recv flags.
+func hasRecvProperty(flags []string, name string) bool {
+	for i := 1; i < len(flags); i += 2 {
+		if strings.SplitN(flags[i], "=", 2)[0] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (operation *Backup) getSendMode() string {
+	var mode string
+
+	if operation.Raw {
+		mode += " raw"
+	}
+
+	if operation.Recursive {
+		mode += " recursive"
+	}
+
+	return mode
+}
+
+func (operation *Backup) resume(
+	log *lorg.Log,
+	targetDataset string,
+	resumeToken string,
+) error {
+	estimate, err := operation.Hosts.Source.EstimateSend(`-t`, resumeToken)
+	if err != nil {
+		return karma.
+			Describe("target", targetDataset).
+			Format(
+				err,
+				"unable to inspect interrupted receive, "+
+					"it can be discarded by using: zfs recv -A %s",
+				targetDataset,
+			)
+	}
+
+	_, current, err := zfs.SplitSnapshotName(estimate.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	var base string
+
+	if estimate.Base != "" {
+		base = estimate.Base
+
+		if strings.Contains(base, "@") {
+			_, base, err = zfs.SplitSnapshotName(base)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Warningf(
+		"found interrupted receive of %q into %q, "+
+			"resuming it instead of creating new snapshot",
+		estimate.Snapshot,
+		targetDataset,
+	)
+
+	log.Infof(
+		"resuming send: %q -> %q (%s left)",
+		estimate.Snapshot,
+		targetDataset,
+		formatting.Size(estimate.Size),
+	)
+
+	operation.Snapshot.Current = current
+	operation.Snapshot.Base = base
+
+	err = zfs.ResumeCopyDataset(
+		operation.Hosts.Source,
+		operation.Hosts.Target,
+		resumeToken,
+		targetDataset,
+		estimate.Size,
+		CreateCopyProgressLogger(
+			operation.NewLog(
+				fmt.Sprintf("{zfs send} resuming %s:", estimate.Snapshot),
+			),
+		),
+		zfs.CopyOptRateLimit(operation.RateLimit),
+		zfs.CopyOptRateLimiter(operation.RateLimiter),
+		zfs.CopyOptBufferSize(operation.BufferSize),
+		zfs.CopyOptCompression(operation.Compression),
+		zfs.CopyOptRecvFlags(operation.getRecvFlags()),
+	)
+	if err != nil {
+		return karma.
+			Describe("source", estimate.Snapshot).
+			Describe("target", targetDataset).
+			Format(
+				err,
+				"unable to resume dataset copy",
+			)
+	}
+
+	log.Infof("interrupted send of %q resumed successfully", estimate.Snapshot)
+
+	return nil
+}
+
+func (operation *Backup) ensureTargetDataset(log *lorg.Log) error {
 	parent := filepath.Dir(
 		fmt.Sprintf("%s/%s", operation.Target, operation.Source),
 	)
 
 	log.Debugf("ensuring required parent dataset %q", parent)
 
-	err := zfs.EnsureDatasetExists(parent)
+	err := operation.Hosts.Target.EnsureDatasetExists(parent)
 	if err != nil {
 		return karma.Format(
 			err,
@@ -136,12 +745,19 @@ func (operation *Backup) ensureTargetDataset() error {
 	return nil
 }
 
-func createCopyProgressLogger(log *lorg.Log) func(progress zfs.CopyProgress) {
+func CreateCopyProgressLogger(log *lorg.Log) func(progress zfs.CopyProgress) {
 	return func(progress zfs.CopyProgress) {
 		if !progress.Sent {
 			running := time.Now().Sub(progress.StartedAt)
 
 			rate := float64(progress.SentSize) / running.Seconds()
+
+			// average rate is higher than limit if limit has been just
+			// applied
+			if progress.RateLimit > 0 && rate > float64(progress.RateLimit) {
+				rate = float64(progress.RateLimit)
+			}
+
 			var left float64
 
 			if progress.SentSize <= progress.TotalSize {
@@ -150,11 +766,37 @@ func createCopyProgressLogger(log *lorg.Log) func(progress zfs.CopyProgress) {
 				left = 0
 			}
 
+			var details string
+
+			if progress.RateLimit > 0 {
+				details += fmt.Sprintf(
+					" | limit %s/s",
+					formatting.Size(progress.RateLimit),
+				)
+			}
+
+			if progress.BufferSize > 0 {
+				details += fmt.Sprintf(
+					" | buffer %d%%",
+					progress.BufferUsed*100/progress.BufferSize,
+				)
+			}
+
+			// replication stream is sent snapshot by snapshot
+			if progress.Snapshot != "" {
+				details += fmt.Sprintf(
+					" | %s of %s",
+					formatting.Size(progress.SnapshotSent),
+					progress.Snapshot,
+				)
+			}
+
 			log.Debugf(
-				"%-8s / %-8s | eta %s",
+				"%-8s / %-8s | eta %s%s",
 				formatting.Size(progress.SentSize),
 				formatting.Size(progress.TotalSize),
 				time.Duration(time.Duration(left)*time.Second),
+				details,
 			)
 		}
 	}
@@ -0,0 +1,274 @@
+package operation
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/kovetskiy/lorg"
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+const (
+	// OnBrokenChainFail makes backup to fail if incremental chain is broken.
+	OnBrokenChainFail = "fail"
+
+	// OnBrokenChainFull makes backup to start new chain by full send into
+	// new target dataset if incremental chain is broken.
+	OnBrokenChainFull = "full"
+)
+
+type chainSnapshot struct {
+	Name      string
+	GUID      string
+	CreateTXG uint64
+}
+
+// chainBase is incremental base which is found on both source and target,
+// Broken is the reason why there is no such base.
+type chainBase struct {
+	Name       string
+	TargetName string
+	GUID       string
+	Broken     string
+}
+
+// checkChain verifies that incremental base found on target by its GUID
+// exists on source and that target has no snapshots newer than base. If it
+// doesn't, the newest common snapshot is used as base instead, unless target
+// has snapshots which are newer than that one. If there is no such snapshot,
+// backup either fails or starts new chain depending on OnBrokenChain. Target
+// dataset which has snapshots, but none of them are made by zeus, is checked
+// the same way, since full stream can't be received into it.
+func (operation *Backup) checkChain(log *lorg.Log, targetDataset string) error {
+	required, err := operation.isChainRequired(targetDataset)
+	if err != nil {
+		return err
+	}
+
+	if !required {
+		return nil
+	}
+
+	base, err := operation.getChainBase(targetDataset)
+	if err != nil {
+		return err
+	}
+
+	if base.Broken == "" {
+		switch {
+		case operation.Snapshot.Base == "":
+			log.Warningf(
+				"target dataset has no snapshots made by zeus, using "+
+					"the newest common snapshot %q (%q on target) as "+
+					"incremental base",
+				base.Name,
+				base.TargetName,
+			)
+
+		case base.GUID != operation.Snapshot.BaseGUID:
+			log.Warningf(
+				"incremental base %q is missing on source or target "+
+					"or it's not the newest snapshot on target, using "+
+					"the newest common snapshot %q (%q on target) as "+
+					"incremental base instead",
+				operation.Snapshot.Base,
+				base.Name,
+				base.TargetName,
+			)
+
+		case base.Name != operation.Snapshot.Base:
+			log.Infof(
+				"incremental base %q is named %q on source",
+				operation.Snapshot.Base,
+				base.Name,
+			)
+		}
+
+		operation.Snapshot.Base = base.Name
+		operation.Snapshot.BaseGUID = base.GUID
+
+		return nil
+	}
+
+	if operation.OnBrokenChain != OnBrokenChainFull {
+		return operation.getBrokenChainError(targetDataset, base.Broken)
+	}
+
+	log.Errorf(
+		"incremental chain is broken: %s; starting new chain by full send "+
+			"into %q, existing backups are kept in %q",
+		operation.getBrokenChainReason(base.Broken),
+		operation.NewChainTarget,
+		operation.Target,
+	)
+
+	operation.Target = operation.NewChainTarget
+	operation.Snapshot.Base = ""
+	operation.Snapshot.BaseGUID = ""
+
+	return operation.ensureTargetDataset(log)
+}
+
+// getChainBase returns the newest common snapshot of source and target
+// which can be used as incremental base, incremental stream can be received
+// only if base is the newest snapshot on target, otherwise target would be
+// rolled back. Nothing is changed neither on source nor on target.
+func (operation *Backup) getChainBase(
+	targetDataset string,
+) (chainBase, error) {
+	source, err := listChainSnapshots(operation.Hosts.Source, operation.Source)
+	if err != nil {
+		return chainBase{}, karma.Format(
+			err,
+			"unable to list snapshots of source dataset",
+		)
+	}
+
+	target, err := listChainSnapshots(operation.Hosts.Target, targetDataset)
+	if err != nil {
+		return chainBase{}, karma.Format(
+			err,
+			"unable to list snapshots of target dataset",
+		)
+	}
+
+	guids := map[string]string{}
+
+	for _, snapshot := range source {
+		guids[snapshot.GUID] = snapshot.Name
+	}
+
+	for i := len(target) - 1; i >= 0; i-- {
+		common, ok := guids[target[i].GUID]
+		if !ok {
+			continue
+		}
+
+		if i == len(target)-1 {
+			return chainBase{
+				Name:       common,
+				TargetName: target[i].Name,
+				GUID:       target[i].GUID,
+			}, nil
+		}
+
+		return chainBase{
+			Broken: "target dataset has snapshots which are newer than the " +
+				"newest common snapshot " + strconv.Quote(common) +
+				", they can't be kept if incremental backup is received",
+		}, nil
+	}
+
+	return chainBase{
+		Broken: "source and target datasets have no common snapshots",
+	}, nil
+}
+
+// isChainRequired reports whether incremental base should be found before
+// sending, which is the case if target dataset already has snapshots.
+func (operation *Backup) isChainRequired(targetDataset string) (bool, error) {
+	if operation.Snapshot.Base != "" {
+		return true, nil
+	}
+
+	snapshots, err := listChainSnapshots(operation.Hosts.Target, targetDataset)
+	if err != nil {
+		return false, karma.Format(
+			err,
+			"unable to list snapshots of target dataset",
+		)
+	}
+
+	return len(snapshots) > 0, nil
+}
+
+func (operation *Backup) getBrokenChainReason(broken string) string {
+	if operation.Snapshot.Base == "" {
+		return "target dataset has no snapshots made by zeus and " + broken
+	}
+
+	return "base snapshot " + strconv.Quote(operation.Snapshot.Base) +
+		" is missing on source or target and " + broken
+}
+
+func (operation *Backup) getBrokenChainError(
+	targetDataset string,
+	reason string,
+) error {
+	return karma.
+		Describe("source", operation.Source).
+		Describe("target", targetDataset).
+		Describe("base", operation.Snapshot.Base).
+		Describe("reason", operation.getBrokenChainReason(reason)).
+		Reason(
+			"incremental chain is broken, set '" +
+				constants.BackupOnBrokenChain + "=" + OnBrokenChainFull +
+				"' to start new chain by full send into new target dataset",
+		)
+}
+
+// listChainSnapshots returns snapshots of given dataset ordered by creation.
+func listChainSnapshots(
+	host zfs.Backend,
+	dataset string,
+) ([]chainSnapshot, error) {
+	exists, err := host.IsDatasetExists(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	mappings, err := host.GetDatasetProperties([]zfs.PropertyRequest{
+		{Name: constants.GUID, System: true, Snapshot: true},
+		{Name: constants.CreateTXG, System: true, Snapshot: true},
+	}, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []chainSnapshot{}
+
+	for _, mapping := range mappings {
+		_, name, err := zfs.SplitSnapshotName(mapping.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot := chainSnapshot{Name: name}
+
+		for _, property := range mapping.Properties {
+			switch property.Name {
+			case constants.GUID:
+				snapshot.GUID = property.Value
+
+			case constants.CreateTXG:
+				snapshot.CreateTXG, err = strconv.ParseUint(
+					property.Value,
+					10,
+					64,
+				)
+				if err != nil {
+					return nil, karma.
+						Describe("snapshot", mapping.Source).
+						Format(
+							err,
+							"unable to parse createtxg",
+						)
+				}
+			}
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreateTXG < snapshots[j].CreateTXG
+	})
+
+	return snapshots, nil
+}
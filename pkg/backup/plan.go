@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/reconquest/zeus/pkg/exec"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+// plannedDestroys is a list of snapshots which would be destroyed on the
+// dataset of a host.
+type plannedDestroys struct {
+	Transport string
+	Dataset   string
+	Snapshots []string
+}
+
+// printPlan outputs commands which would be executed if dry run mode was not
+// enabled, followed by snapshots which would be destroyed by housekeeping
+// grouped per dataset.
+func printPlan() {
+	plan := exec.GetPlan()
+
+	if len(plan) == 0 {
+		fmt.Println("dry run: no changes would be made")
+
+		return
+	}
+
+	fmt.Println("dry run: following commands would be executed:")
+
+	for i, command := range plan {
+		fmt.Printf("%4d. %s\n", i+1, command)
+	}
+
+	destroys := getPlannedDestroys(plan)
+	if len(destroys) == 0 {
+		return
+	}
+
+	fmt.Println("dry run: following snapshots would be destroyed:")
+
+	for _, group := range destroys {
+		fmt.Printf("  [%s] %s:\n", group.Transport, group.Dataset)
+
+		for _, snapshot := range group.Snapshots {
+			fmt.Printf("    - %s\n", snapshot)
+		}
+	}
+}
+
+// getPlannedDestroys groups planned snapshot destroys by host and dataset
+// keeping order in which datasets are met in the plan.
+func getPlannedDestroys(plan []exec.PlannedCommand) []plannedDestroys {
+	var (
+		destroys = []plannedDestroys{}
+		indexes  = map[string]int{}
+	)
+
+	for _, command := range plan {
+		if len(command.Command) != 3 ||
+			command.Command[0] != "zfs" ||
+			command.Command[1] != "destroy" {
+			continue
+		}
+
+		dataset, snapshot, err := zfs.SplitSnapshotName(command.Command[2])
+		if err != nil {
+			// not a snapshot
+			continue
+		}
+
+		key := command.Transport + " " + dataset
+
+		index, ok := indexes[key]
+		if !ok {
+			index = len(destroys)
+			indexes[key] = index
+
+			destroys = append(destroys, plannedDestroys{
+				Transport: command.Transport,
+				Dataset:   dataset,
+			})
+		}
+
+		destroys[index].Snapshots = append(destroys[index].Snapshots, snapshot)
+	}
+
+	return destroys
+}
@@ -0,0 +1,39 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/reconquest/zeus/pkg/exec"
+)
+
+func TestGetPlannedDestroys(t *testing.T) {
+	planned := func(transport string, args ...string) exec.PlannedCommand {
+		return exec.PlannedCommand{
+			Transport: transport,
+			Command:   append([]string{"zfs"}, args...),
+		}
+	}
+
+	plan := []exec.PlannedCommand{
+		planned("local", "snapshot", "z/home@c"),
+		planned("local", "destroy", "z/home@a"),
+		planned("ssh backup", "destroy", "zbackup/vm/z/home@a"),
+		planned("local", "destroy", "z/var@a"),
+		planned("local", "destroy", "z/home@b"),
+		planned("local", "destroy", "z/tmp"),
+	}
+
+	expected := []plannedDestroys{
+		{"local", "z/home", []string{"a", "b"}},
+		{"ssh backup", "zbackup/vm/z/home", []string{"a"}},
+		{"local", "z/var", []string{"a"}},
+	}
+
+	if destroys := getPlannedDestroys(plan); !reflect.DeepEqual(
+		destroys,
+		expected,
+	) {
+		t.Errorf("unexpected planned destroys: %v", destroys)
+	}
+}
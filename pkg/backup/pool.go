@@ -0,0 +1,291 @@
+package backup
+
+import (
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/exec"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+// getBackends returns backends of source datasets and target backup pool.
+// Backends which are not overriden are local zfs for source and host where
+// target backup pool is attached for target.
+func getBackends(
+	config *config.Config,
+	backend OptBackend,
+) (zfs.Backend, zfs.Backend) {
+	var (
+		source = backend.Source
+		target = backend.Target
+	)
+
+	if source == nil {
+		source = zfs.Local
+	}
+
+	if target == nil {
+		target = getTargetHost(config)
+	}
+
+	return source, target
+}
+
+// getTargetHost returns host where target backup pool is attached.
+func getTargetHost(config *config.Config) zfs.Backend {
+	if config.Remote.Host == "" {
+		return zfs.Local
+	}
+
+	return zfs.NewHost(exec.SSHTransport{
+		Host:         config.Remote.Host,
+		User:         config.Remote.User,
+		Port:         config.Remote.Port,
+		IdentityFile: config.Remote.IdentityFile,
+	}).SetZeusCommand(config.Remote.Zeus)
+}
+
+// isPoolManaged reports whether target pool should be imported and exported
+// by zeus. Pools on remote hosts are expected to be imported unless
+// configured otherwise.
+func isPoolManaged(config *config.Config, host zfs.Backend) bool {
+	if config.IsTargetPermanent() {
+		log.Infof(
+			"target backup pool %q is permanent and expected to be imported",
+			config.TargetPool,
+		)
+
+		return false
+	}
+
+	if host.IsLocal() || config.Remote.ManagePool {
+		return true
+	}
+
+	log.Infof(
+		"target backup pool %q on remote host %q is expected to be imported",
+		config.TargetPool,
+		host,
+	)
+
+	return false
+}
+
+// importPool imports pool with given name unless it's already imported and
+// reports whether pool has been imported by this call.
+func importPool(host zfs.Backend, name string) (bool, error) {
+	log.Debugf("checking that target backup pool is imported")
+
+	if ok, err := isPoolImported(host, name); !ok {
+		if err != nil {
+			return false, err
+		}
+
+		// without imported pool state of the target can't be inspected and
+		// plan would be far from what is going to happen
+		if exec.IsDryRun() {
+			return false, karma.
+				Describe("pool", name).
+				Reason(
+					"target backup pool is not imported, " +
+						"it should be imported to make a dry run",
+				)
+		}
+
+		log.Infof(
+			"target backup pool %q is not imported, importing pool",
+			name,
+		)
+
+		err := host.ImportPool(name)
+		if err != nil {
+			return false, karma.Format(
+				err,
+				"unable to import pool",
+			)
+		}
+
+		return true, nil
+	}
+
+	log.Infof(
+		"target backup %q pool is already imported",
+		name,
+	)
+
+	return false, nil
+}
+
+func exportPool(host zfs.Backend, name string) {
+	log.Infof("exporting target backup pool %q", name)
+
+	err := host.ExportPool(name)
+	if err != nil {
+		log.Errorf(karma.Format(
+			err,
+			"unable to export pool",
+		).String())
+	}
+}
+
+// requirePoolImported returns error if pool which is not managed by zeus is
+// not imported.
+func requirePoolImported(host zfs.Backend, name string) error {
+	imported, err := isPoolImported(host, name)
+	if err != nil {
+		return err
+	}
+
+	if !imported {
+		return karma.
+			Describe("pool", name).
+			Describe("host", host).
+			Reason(
+				"target backup pool is not imported and zeus is not " +
+					"configured to import it, import it manually",
+			)
+	}
+
+	return nil
+}
+
+// isPoolImported checks that pool is imported. Pools listed by zpool import
+// are only available for import, so they are not considered imported.
+func isPoolImported(host zfs.Backend, name string) (bool, error) {
+	ok, err := isPoolInImportedList(host, name)
+	if err != nil {
+		return false, karma.Format(
+			err,
+			"unable to check that pool is in imported list",
+		)
+	}
+
+	return ok, nil
+}
+
+func isPoolInImportedList(host zfs.Backend, name string) (bool, error) {
+	importedPools, err := host.GetImportedPools()
+	if err != nil {
+		return false, err
+	}
+
+	for _, importedPoolName := range importedPools {
+		if importedPoolName == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// selectTargets returns targets which backup should be made to. If multiple
+// targets are configured, only ones which are imported or available for
+// import are returned, either all of them or the first one depending on
+// target selection.
+func selectTargets(
+	config *config.Config,
+	host zfs.Backend,
+) ([]config.Target, error) {
+	targets := config.GetTargets()
+
+	if len(targets) == 1 {
+		return targets, nil
+	}
+
+	available := map[string]bool{}
+
+	imported, err := host.GetImportedPools()
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to get imported pools",
+		)
+	}
+
+	for _, name := range imported {
+		available[name] = true
+	}
+
+	if !config.IsTargetPermanent() {
+		importable, err := host.GetImportList()
+		if err != nil {
+			return nil, karma.Format(
+				err,
+				"unable to get pools available for import",
+			)
+		}
+
+		for _, name := range importable {
+			available[name] = true
+		}
+	}
+
+	selected := config.SelectTargets(func(pool string) bool {
+		if !available[pool] {
+			log.Infof(
+				"target backup pool %q is not available, skipping",
+				pool,
+			)
+
+			return false
+		}
+
+		return true
+	})
+
+	if len(selected) == 0 {
+		pools := []string{}
+		for _, target := range targets {
+			pools = append(pools, target.Pool)
+		}
+
+		return nil, karma.
+			Describe("pools", pools).
+			Describe("host", host).
+			Reason("none of target backup pools is available")
+	}
+
+	return selected, nil
+}
+
+// getAvailableTarget returns config which uses the first available target
+// backup pool, so backups can be inspected and restored from any of rotated
+// pools which is currently attached.
+func getAvailableTarget(
+	config *config.Config,
+	host zfs.Backend,
+) (*config.Config, error) {
+	targets, err := selectTargets(config, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.WithTarget(targets[0]), nil
+}
+
+// getPoolGUID returns guid of the given pool, which is used to tell target
+// backup pools apart regardless of their names.
+func getPoolGUID(host zfs.Backend, name string) (string, error) {
+	mappings, err := host.GetPoolProperties([]zfs.PropertyRequest{
+		{Name: constants.GUID},
+	}, name)
+	if err != nil {
+		return "", karma.Format(
+			err,
+			"unable to get pool %q properties",
+			name,
+		)
+	}
+
+	for _, mapping := range mappings {
+		for _, property := range mapping.Properties {
+			if property.Name == constants.GUID {
+				return property.Value, nil
+			}
+		}
+	}
+
+	return "", karma.
+		Describe("pool", name).
+		Reason("pool guid is not found")
+}
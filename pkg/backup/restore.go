@@ -0,0 +1,349 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/backup/operation"
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/exec"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+type (
+	OptForce    bool
+	OptSnapshot string
+)
+
+func Restore(
+	config *config.Config,
+	source string,
+	target string,
+	opts ...Opts,
+) error {
+	var (
+		noExport bool
+		force    bool
+		snapshot string
+		backend  OptBackend
+	)
+
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case OptNoExport:
+			noExport = bool(opt)
+		case OptForce:
+			force = bool(opt)
+		case OptSnapshot:
+			snapshot = string(opt)
+		case OptBackend:
+			backend = opt
+		}
+	}
+
+	// backup is restored from the target backup pool into source datasets
+	local, backupHost := getBackends(config, backend)
+
+	config, err := getAvailableTarget(config, backupHost)
+	if err != nil {
+		return err
+	}
+
+	targetDatasetName := fmt.Sprintf(
+		"%s/%s",
+		config.TargetPool,
+		config.TargetDataset,
+	)
+
+	if exec.IsDryRun() {
+		log.Warningf("dry run mode enabled, no changes will be made")
+
+		defer printPlan()
+	}
+
+	log.Infof("target backup host: %q", backupHost)
+	log.Infof("target backup pool: %q", config.TargetPool)
+	log.Infof("target backup dataset: %q", targetDatasetName)
+
+	exists, err := local.IsDatasetExists(target)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		if !force {
+			return karma.
+				Describe("dataset", target).
+				Reason(
+					"restore destination already exists, " +
+						"use --force to overwrite it",
+				)
+		}
+
+		log.Warningf(
+			"restore destination %q already exists and will be overwritten",
+			target,
+		)
+	}
+
+	if isPoolManaged(config, backupHost) {
+		_, err = importPool(backupHost, config.TargetPool)
+		if err != nil {
+			return err
+		}
+
+		if !noExport {
+			defer exportPool(backupHost, config.TargetPool)
+		}
+	} else {
+		err = requirePoolImported(backupHost, config.TargetPool)
+		if err != nil {
+			return err
+		}
+	}
+
+	encrypted, encryptionRoot, err := loadEncryptionKey(
+		config,
+		backupHost,
+		config.TargetPool,
+	)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to load encryption key",
+		)
+	}
+
+	if encrypted {
+		defer func() {
+			log.Infof("unloading encryption key from %q", encryptionRoot)
+			err := backupHost.UnloadKey(encryptionRoot)
+			if err != nil {
+				log.Error(karma.Format(
+					err,
+					"unable to unload encryption key for %q",
+					encryptionRoot,
+				).String())
+			}
+		}()
+	}
+
+	log.Debugf("listing snapshots on the backup dataset %q", targetDatasetName)
+
+	restoreSnapshot, err := getRestoreSnapshot(
+		config,
+		backupHost,
+		targetDatasetName,
+		source,
+		snapshot,
+	)
+	if err != nil {
+		return err
+	}
+
+	sourceSnapshot := fmt.Sprintf(
+		"%s@%s",
+		restoreSnapshot.Dataset,
+		restoreSnapshot.Name,
+	)
+
+	if parent := path.Dir(target); parent != "." {
+		err = local.EnsureDatasetExists(parent)
+		if err != nil {
+			return karma.Format(
+				err,
+				"unable to create restore destination hierarchy",
+			)
+		}
+	}
+
+	bufferSize, err := getBufferSize(config)
+	if err != nil {
+		return err
+	}
+
+	raw, err := hasSnapshotMark(backupHost, sourceSnapshot, constants.Raw)
+	if err != nil {
+		return err
+	}
+
+	recursive, err := hasSnapshotMark(
+		backupHost,
+		sourceSnapshot,
+		constants.Recursive,
+	)
+	if err != nil {
+		return err
+	}
+
+	if raw {
+		log.Infof(
+			"snapshot %q has been received raw, restoring it raw, "+
+				"key of the source dataset is required to mount %q",
+			sourceSnapshot,
+			target,
+		)
+	}
+
+	var recvFlags []string
+
+	if recursive {
+		log.Infof(
+			"snapshot %q has been received recursively, restoring it "+
+				"along with snapshots of descendants",
+			sourceSnapshot,
+		)
+
+		// replication stream contains properties, while volumes are
+		// received with volmode=none on backup pool
+		recvFlags = append(recvFlags, `-x`, constants.Volmode)
+	}
+
+	log.Infof("starting restore: %q -> %q", sourceSnapshot, target)
+
+	err = zfs.CopyDataset(
+		backupHost,
+		local,
+		sourceSnapshot,
+		target,
+		"",
+		operation.CreateCopyProgressLogger(
+			log.NewChildWithPrefix(
+				fmt.Sprintf("{zfs send} restoring %s:", sourceSnapshot),
+			),
+		),
+		zfs.CopyOptForce(force),
+		zfs.CopyOptBufferSize(bufferSize),
+		zfs.CopyOptRaw(raw),
+		zfs.CopyOptRecursive(recursive),
+		zfs.CopyOptRecvFlags(recvFlags),
+	)
+	if err != nil {
+		return karma.
+			Describe("source", sourceSnapshot).
+			Describe("target", target).
+			Format(
+				err,
+				"unable to perform dataset copy",
+			)
+	}
+
+	log.Infof(
+		"restore %q -> %q completed successfully",
+		sourceSnapshot,
+		target,
+	)
+
+	return nil
+}
+
+// getRestoreSnapshot finds snapshot of given source dataset on the target
+// backup dataset. If snapshot name is not specified, latest snapshot made by
+// zeus is returned.
+func getRestoreSnapshot(
+	config *config.Config,
+	backupHost zfs.Backend,
+	targetDatasetName string,
+	source string,
+	snapshot string,
+) (targetSnapshot, error) {
+	snapshots, err := listTargetSnapshots(backupHost, targetDatasetName)
+	if err != nil {
+		return targetSnapshot{}, karma.Format(
+			err,
+			"unable to retrieve existing snapshots in backup dataset",
+		)
+	}
+
+	var (
+		candidates = []targetSnapshot{}
+		namespaces = map[string]bool{}
+	)
+
+	for _, candidate := range snapshots {
+		if candidate.Source != source {
+			continue
+		}
+
+		if snapshot != "" {
+			if candidate.Name != snapshot {
+				continue
+			}
+		} else {
+			if !strings.HasPrefix(candidate.Name, config.SnapshotPrefix) {
+				continue
+			}
+		}
+
+		candidates = append(candidates, candidate)
+		namespaces[candidate.Namespace] = true
+	}
+
+	facts := karma.
+		Describe("source", source).
+		Describe("dataset", targetDatasetName)
+
+	if len(candidates) == 0 {
+		if snapshot != "" {
+			return targetSnapshot{}, facts.
+				Describe("snapshot", snapshot).
+				Reason("no such snapshot found in backup dataset")
+		}
+
+		return targetSnapshot{}, facts.
+			Reason("no snapshots found for given source in backup dataset")
+	}
+
+	if len(namespaces) > 1 {
+		log.Warningf(
+			"source %q has been backed up into %d different namespaces, "+
+				"picking latest snapshot among them",
+			source,
+			len(namespaces),
+		)
+	}
+
+	// snapshot names are suffixed with RFC3339 timestamp in UTC, so they can
+	// be compared lexicographically
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	return candidates[len(candidates)-1], nil
+}
+
+// hasSnapshotMark reports whether given backup snapshot is marked with given
+// mark. Snapshots received from raw send should be sent raw as well since
+// backup host doesn't have their key, while snapshots received from
+// replication stream should be sent along with snapshots of descendants.
+func hasSnapshotMark(
+	host zfs.Backend,
+	snapshot string,
+	mark string,
+) (bool, error) {
+	mappings, err := host.GetDatasetProperties([]zfs.PropertyRequest{
+		{Name: mark, Snapshot: true, Local: true},
+	}, snapshot)
+	if err != nil {
+		return false, karma.Format(
+			err,
+			"unable to check that snapshot %q is marked with %q",
+			snapshot,
+			mark,
+		)
+	}
+
+	for _, mapping := range mappings {
+		for _, property := range mapping.Properties {
+			if property.Name == mark && property.Value == "yes" {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	backupStatusOK      = "ok"
+	backupStatusFailed  = "failed"
+	backupStatusSkipped = "skipped"
+)
+
+type (
+	backupResult struct {
+		Source string
+		Status string
+		Reason string
+	}
+
+	backupSummary struct {
+		OK      int
+		Failed  int
+		Skipped int
+	}
+)
+
+func summarizeBackupResults(results []backupResult) backupSummary {
+	var summary backupSummary
+
+	for _, result := range results {
+		switch result.Status {
+		case backupStatusOK:
+			summary.OK++
+		case backupStatusFailed:
+			summary.Failed++
+		case backupStatusSkipped:
+			summary.Skipped++
+		}
+	}
+
+	return summary
+}
+
+func formatBackupResults(results []backupResult) string {
+	var (
+		buffer  bytes.Buffer
+		summary = summarizeBackupResults(results)
+	)
+
+	fmt.Fprintf(
+		&buffer,
+		"backup summary: %d ok, %d failed, %d skipped\n",
+		summary.OK,
+		summary.Failed,
+		summary.Skipped,
+	)
+
+	writer := tabwriter.NewWriter(&buffer, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(writer, "DATASET\tSTATUS\tREASON")
+
+	for _, result := range results {
+		reason := result.Reason
+		if reason == "" {
+			reason = "-"
+		}
+
+		// only first line is shown, full error is logged when it happens
+		reason = strings.SplitN(reason, "\n", 2)[0]
+
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\n",
+			result.Source,
+			result.Status,
+			reason,
+		)
+	}
+
+	writer.Flush()
+
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
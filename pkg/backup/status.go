@@ -0,0 +1,659 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/backup/errs"
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/duration"
+	"github.com/reconquest/zeus/pkg/formatting"
+	"github.com/reconquest/zeus/pkg/text"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+type (
+	OptMaxAge string
+)
+
+type (
+	statusReport struct {
+		Targets  []statusTarget `json:"targets"`
+		Problems []string       `json:"problems"`
+	}
+
+	statusTarget struct {
+		Pool     statusPool      `json:"pool"`
+		Datasets []statusDataset `json:"datasets"`
+	}
+
+	statusPool struct {
+		Name      string `json:"name"`
+		Available bool   `json:"available"`
+		Health    string `json:"health"`
+		Capacity  uint64 `json:"capacity"`
+		Free      uint64 `json:"free"`
+	}
+
+	statusDataset struct {
+		Source         string          `json:"source"`
+		Target         string          `json:"target"`
+		SourceSnapshot *statusSnapshot `json:"source_snapshot"`
+		TargetSnapshot *statusSnapshot `json:"target_snapshot"`
+		ChainIntact    bool            `json:"chain_intact"`
+		MaxAge         string          `json:"max_age"`
+		Stale          bool            `json:"stale"`
+	}
+
+	statusSnapshot struct {
+		Name    string    `json:"name"`
+		GUID    string    `json:"guid"`
+		Created time.Time `json:"created"`
+	}
+)
+
+func Status(config *config.Config, opts ...Opts) error {
+	var (
+		format = "text"
+		clock  = time.Now
+	)
+
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case OptFormat:
+			format = string(opt)
+		case OptNow:
+			clock = opt
+		}
+	}
+
+	switch format {
+	case "text", "json":
+		// ok
+	default:
+		return karma.
+			Describe("format", format).
+			Reason(
+				"unsupported output format, supported formats are: " +
+					"'text', 'json'",
+			)
+	}
+
+	now := clock()
+
+	report, err := getStatusReport(config, now, opts...)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		err = encoder.Encode(report)
+
+	default:
+		err = printStatusReport(report, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(report.Problems) > 0 {
+		return errs.StatusCritical{
+			Problems: report.Problems,
+		}
+	}
+
+	return nil
+}
+
+// getStatusReport inspects every configured target backup pool. Dataset is
+// considered stale if its latest backup is older than max age or than backup
+// interval of the dataset, whichever is longer.
+func getStatusReport(
+	config *config.Config,
+	now time.Time,
+	opts ...Opts,
+) (statusReport, error) {
+	var (
+		noExport bool
+		maxAge   = config.Status.MaxAge
+		backend  OptBackend
+	)
+
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case OptNoExport:
+			noExport = bool(opt)
+		case OptMaxAge:
+			if opt != "" {
+				maxAge = string(opt)
+			}
+		case OptBackend:
+			backend = opt
+		}
+	}
+
+	report := statusReport{
+		Targets:  []statusTarget{},
+		Problems: []string{},
+	}
+
+	maxAgeInterval, err := duration.Parse(maxAge)
+	if err != nil {
+		return report, karma.
+			Describe("max_age", maxAge).
+			Format(
+				err,
+				"unable to parse max age of backups",
+			)
+	}
+
+	source, host := getBackends(config, backend)
+
+	for _, target := range config.GetTargets() {
+		status, err := getStatusTarget(
+			config.WithTarget(target),
+			source,
+			host,
+			maxAgeInterval,
+			noExport,
+			now,
+		)
+		if err != nil {
+			return report, err
+		}
+
+		report.Targets = append(report.Targets, status)
+	}
+
+	report.Problems = getStatusProblems(config, report.Targets, now)
+
+	return report, nil
+}
+
+func getStatusTarget(
+	config *config.Config,
+	source zfs.Backend,
+	target zfs.Backend,
+	maxAge time.Duration,
+	noExport bool,
+	now time.Time,
+) (statusTarget, error) {
+	targetDatasetName := fmt.Sprintf(
+		"%s/%s",
+		config.TargetPool,
+		config.TargetDataset,
+	)
+
+	status := statusTarget{
+		Pool: statusPool{
+			Name:      config.TargetPool,
+			Available: true,
+		},
+		Datasets: []statusDataset{},
+	}
+
+	if isPoolManaged(config, target) {
+		imported, err := importPool(target, config.TargetPool)
+		if err != nil {
+			log.Error(err)
+
+			status.Pool.Available = false
+		}
+
+		if imported && !noExport {
+			defer exportPool(target, config.TargetPool)
+		}
+	} else {
+		err := requirePoolImported(target, config.TargetPool)
+		if err != nil {
+			log.Error(err)
+
+			status.Pool.Available = false
+		}
+	}
+
+	if status.Pool.Available {
+		err := getStatusPool(target, &status.Pool)
+		if err != nil {
+			log.Error(err)
+
+			status.Pool.Available = false
+		}
+	}
+
+	operations, err := getBackupOperations(config, source)
+	if err != nil {
+		return status, karma.Format(
+			err,
+			"unable to retrieve datasets for backup",
+		)
+	}
+
+	targetSnapshotsBySource := map[string]latestSnapshot{}
+
+	if status.Pool.Available {
+		targetSnapshotsBySource, err = getLatestTargetSnapshotsBySource(
+			target,
+			targetDatasetName,
+		)
+		if err != nil {
+			return status, karma.Format(
+				err,
+				"unable to retrieve exising snapshots in backup dataset",
+			)
+		}
+	}
+
+	for _, operation := range operations {
+		dataset := statusDataset{
+			Source: operation.Source,
+			Target: fmt.Sprintf(
+				"%s/%s/%s",
+				targetDatasetName,
+				getCurrentNamespace(
+					operation.GUID,
+					operation.Source,
+					getSnapshotDatasets(targetSnapshotsBySource),
+				),
+				operation.Source,
+			),
+		}
+
+		// dataset which is backed up less often than max age would be
+		// reported as stale between backups otherwise
+		threshold := maxAge
+		if operation.Interval > threshold {
+			threshold = operation.Interval
+		}
+
+		dataset.MaxAge = threshold.String()
+
+		sourceSnapshots, err := getStatusSnapshots(source, dataset.Source)
+		if err != nil {
+			return status, karma.Format(
+				err,
+				"unable to list managed snapshots of %q",
+				dataset.Source,
+			)
+		}
+
+		if len(sourceSnapshots) > 0 {
+			dataset.SourceSnapshot = &sourceSnapshots[len(sourceSnapshots)-1]
+		}
+
+		if status.Pool.Available {
+			targetSnapshots, err := getStatusSnapshots(target, dataset.Target)
+			if err != nil {
+				return status, karma.Format(
+					err,
+					"unable to list managed snapshots of %q",
+					dataset.Target,
+				)
+			}
+
+			if count := len(targetSnapshots); count > 0 {
+				dataset.TargetSnapshot = &targetSnapshots[count-1]
+			}
+
+			// snapshot can be renamed, so it's matched by guid
+			if dataset.TargetSnapshot != nil {
+				for _, snapshot := range sourceSnapshots {
+					if snapshot.GUID == dataset.TargetSnapshot.GUID {
+						dataset.ChainIntact = true
+					}
+				}
+			}
+		}
+
+		dataset.Stale = dataset.TargetSnapshot == nil ||
+			now.Sub(dataset.TargetSnapshot.Created) > threshold
+
+		status.Datasets = append(status.Datasets, dataset)
+	}
+
+	return status, nil
+}
+
+// getStatusProblems returns problems found on target backup pools. If
+// backups are made only to the first available pool, pools are used in
+// rotation, so it's enough for one of them to be available and for every
+// dataset to be recently backed up to any of them.
+func getStatusProblems(
+	config *config.Config,
+	targets []statusTarget,
+	now time.Time,
+) []string {
+	type backup struct {
+		pool    string
+		dataset statusDataset
+	}
+
+	var (
+		problems  = []string{}
+		rotated   = config.IsTargetRotated()
+		available int
+		backups   = map[string][]backup{}
+		sources   = []string{}
+	)
+
+	for _, target := range targets {
+		if !target.Pool.Available {
+			continue
+		}
+
+		available++
+
+		if target.Pool.Health != "ONLINE" {
+			problems = append(
+				problems,
+				fmt.Sprintf(
+					"pool %q health is %s",
+					target.Pool.Name,
+					target.Pool.Health,
+				),
+			)
+		}
+
+		for _, dataset := range target.Datasets {
+			if _, ok := backups[dataset.Source]; !ok {
+				sources = append(sources, dataset.Source)
+			}
+
+			backups[dataset.Source] = append(
+				backups[dataset.Source],
+				backup{pool: target.Pool.Name, dataset: dataset},
+			)
+
+			if dataset.TargetSnapshot != nil && !dataset.ChainIntact {
+				problems = append(
+					problems,
+					fmt.Sprintf(
+						"dataset %q incremental chain is broken: "+
+							"latest backup %q on pool %q "+
+							"has no matching source snapshot",
+						dataset.Source,
+						dataset.TargetSnapshot.Name,
+						target.Pool.Name,
+					),
+				)
+			}
+		}
+	}
+
+	for _, target := range targets {
+		if target.Pool.Available || rotated && available > 0 {
+			continue
+		}
+
+		problems = append(
+			problems,
+			fmt.Sprintf("pool %q is not available", target.Pool.Name),
+		)
+	}
+
+	for _, source := range sources {
+		stale := []backup{}
+
+		for _, backup := range backups[source] {
+			if backup.dataset.Stale {
+				stale = append(stale, backup)
+			}
+		}
+
+		if rotated && len(stale) < len(backups[source]) {
+			continue
+		}
+
+		for _, backup := range stale {
+			if backup.dataset.TargetSnapshot == nil {
+				problems = append(
+					problems,
+					fmt.Sprintf(
+						"dataset %q has no backups on pool %q",
+						source,
+						backup.pool,
+					),
+				)
+
+				continue
+			}
+
+			problems = append(
+				problems,
+				fmt.Sprintf(
+					"dataset %q was backed up to pool %q %s ago",
+					source,
+					backup.pool,
+					formatAge(now, backup.dataset.TargetSnapshot),
+				),
+			)
+		}
+	}
+
+	return problems
+}
+
+func getStatusPool(host zfs.Backend, pool *statusPool) error {
+	mappings, err := host.GetPoolProperties([]zfs.PropertyRequest{
+		{Name: constants.Health},
+		{Name: constants.Capacity},
+		{Name: constants.Free},
+	}, pool.Name)
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to get pool %q properties",
+			pool.Name,
+		)
+	}
+
+	for _, mapping := range mappings {
+		for _, property := range mapping.Properties {
+			switch property.Name {
+			case constants.Health:
+				pool.Health = property.Value
+
+			case constants.Capacity, constants.Free:
+				value, err := strconv.ParseUint(
+					strings.TrimSuffix(property.Value, "%"),
+					10,
+					64,
+				)
+				if err != nil {
+					return karma.
+						Describe("property", property.Name).
+						Describe("value", property.Value).
+						Format(
+							err,
+							"unable to parse pool property value",
+						)
+				}
+
+				if property.Name == constants.Capacity {
+					pool.Capacity = value
+				} else {
+					pool.Free = value
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// getStatusSnapshots returns snapshots of given dataset marked as managed by
+// zeus, sorted by creation time.
+func getStatusSnapshots(
+	host zfs.Backend,
+	dataset string,
+) ([]statusSnapshot, error) {
+	exists, err := host.IsDatasetExists(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	mappings, err := host.GetDatasetProperties([]zfs.PropertyRequest{
+		{Name: constants.Managed, Snapshot: true, Local: true},
+		{Name: constants.GUID, Snapshot: true, System: true},
+		{Name: constants.Creation, Snapshot: true, System: true},
+	}, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []statusSnapshot{}
+
+	for _, mapping := range mappings {
+		var (
+			snapshot statusSnapshot
+			managed  bool
+		)
+
+		_, snapshot.Name, err = zfs.SplitSnapshotName(mapping.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, property := range mapping.Properties {
+			switch property.Name {
+			case constants.Managed:
+				managed = true
+
+			case constants.GUID:
+				snapshot.GUID = property.Value
+
+			case constants.Creation:
+				created, err := strconv.ParseInt(property.Value, 10, 64)
+				if err != nil {
+					return nil, karma.
+						Describe("snapshot", mapping.Source).
+						Format(
+							err,
+							"unable to parse snapshot creation time",
+						)
+				}
+
+				snapshot.Created = time.Unix(created, 0)
+			}
+		}
+
+		if managed {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+
+	return snapshots, nil
+}
+
+func formatAge(now time.Time, snapshot *statusSnapshot) string {
+	if snapshot == nil {
+		return "-"
+	}
+
+	return now.Sub(snapshot.Created).Truncate(time.Second).String()
+}
+
+func printStatusReport(report statusReport, now time.Time) error {
+	var datasets, available int
+
+	for _, target := range report.Targets {
+		if target.Pool.Available {
+			available++
+		}
+
+		if len(target.Datasets) > datasets {
+			datasets = len(target.Datasets)
+		}
+	}
+
+	if len(report.Problems) > 0 {
+		fmt.Printf(
+			"ZEUS CRITICAL - %s\n",
+			strings.Join(report.Problems, "; "),
+		)
+	} else {
+		fmt.Printf(
+			"ZEUS OK - %d %s backed up, %d of %d %s available\n",
+			datasets,
+			text.Pluralize("dataset", datasets),
+			available,
+			len(report.Targets),
+			text.Pluralize("pool", len(report.Targets)),
+		)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	for _, target := range report.Targets {
+		fmt.Fprintln(writer)
+
+		if !target.Pool.Available {
+			fmt.Fprintf(writer, "pool %s: not available\n", target.Pool.Name)
+
+			continue
+		}
+
+		fmt.Fprintf(
+			writer,
+			"pool %s: health %s, capacity %d%%, free %s\n",
+			target.Pool.Name,
+			target.Pool.Health,
+			target.Pool.Capacity,
+			formatting.Size(target.Pool.Free),
+		)
+
+		fmt.Fprintln(
+			writer,
+			"DATASET\tSOURCE AGE\tTARGET AGE\tMAX AGE\tCHAIN\tSTATUS",
+		)
+
+		for _, dataset := range target.Datasets {
+			chain := "-"
+			if dataset.TargetSnapshot != nil {
+				if dataset.ChainIntact {
+					chain = "intact"
+				} else {
+					chain = "broken"
+				}
+			}
+
+			status := "ok"
+			if dataset.Stale {
+				status = "stale"
+			}
+
+			fmt.Fprintf(
+				writer,
+				"%s\t%s\t%s\t%s\t%s\t%s\n",
+				dataset.Source,
+				formatAge(now, dataset.SourceSnapshot),
+				formatAge(now, dataset.TargetSnapshot),
+				dataset.MaxAge,
+				chain,
+				status,
+			)
+		}
+	}
+
+	return writer.Flush()
+}
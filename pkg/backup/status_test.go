@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/zfs/fake"
+)
+
+func getTestStatusReport(
+	t *testing.T,
+	config *config.Config,
+	source *fake.Backend,
+	target *fake.Backend,
+	now time.Time,
+) statusReport {
+	report, err := getStatusReport(
+		config,
+		now,
+		OptBackend{Source: source, Target: target},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return report
+}
+
+func TestStatus_UsesBackupInterval(t *testing.T) {
+	config, source, target := newTestBackup(t)
+
+	err := runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := testTime.Add(3 * 24 * time.Hour)
+
+	report := getTestStatusReport(t, config, source, target, now)
+	if len(report.Problems) != 1 {
+		t.Fatalf("expected backup to be stale: %v", report.Problems)
+	}
+
+	// dataset is backed up once a week, so it's not stale after 3 days
+	err = source.SetDatasetProperty(
+		testSourceDataset,
+		constants.BackupInterval,
+		"7d",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report = getTestStatusReport(t, config, source, target, now)
+	if len(report.Problems) != 0 {
+		t.Fatalf("unexpected problems: %v", report.Problems)
+	}
+
+	if dataset := report.Targets[0].Datasets[0]; dataset.Stale ||
+		dataset.MaxAge != (7*24*time.Hour).String() {
+		t.Errorf("unexpected dataset status: %+v", dataset)
+	}
+}
+
+func TestStatus_ReportsEveryTarget(t *testing.T) {
+	var (
+		selectAll = config.TargetSelectionAll
+		targets   = []config.Target{
+			{Pool: "zbackup", Dataset: "vm", HoldTag: "zeus:zbackup"},
+			{Pool: "zoffsite", Dataset: "vm", HoldTag: "zeus:zoffsite"},
+			{Pool: "zmissing", Dataset: "vm", HoldTag: "zeus:zmissing"},
+		}
+	)
+
+	config, source, target := newTestBackup(t)
+
+	config.Targets = targets
+
+	err := runTestBackup(t, config, source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// off-site pool is attached after backup, so it has no backups yet
+	target.AddPool("zoffsite", false)
+
+	now := testTime.Add(time.Hour)
+
+	report := getTestStatusReport(t, config, source, target, now)
+
+	pools := []string{}
+	for _, target := range report.Targets {
+		pools = append(pools, target.Pool.Name)
+
+		if available := target.Pool.Name != "zmissing"; available !=
+			target.Pool.Available {
+			t.Errorf("unexpected pool status: %+v", target.Pool)
+		}
+	}
+
+	if !reflect.DeepEqual(pools, []string{"zbackup", "zoffsite", "zmissing"}) {
+		t.Errorf("unexpected pools: %v", pools)
+	}
+
+	// pools are used in rotation, so it's enough to have backup on any pool
+	if len(report.Problems) != 0 {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+
+	config.TargetSelection = selectAll
+
+	report = getTestStatusReport(t, config, source, target, now)
+
+	if !reflect.DeepEqual(report.Problems, []string{
+		`pool "zmissing" is not available`,
+		`dataset "z/home" has no backups on pool "zoffsite"`,
+	}) {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+}
@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/backup/operation"
+	"github.com/reconquest/zeus/pkg/config"
+	"github.com/reconquest/zeus/pkg/formatting"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+const (
+	rateUnlimited = "unlimited"
+)
+
+var (
+	reTimeOfDay = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+)
+
+// getRateLimit returns send rate limit configured by max_rate and
+// rate_windows.
+func getRateLimit(config *config.Config) (zfs.RateLimit, error) {
+	var (
+		limit zfs.RateLimit
+		err   error
+	)
+
+	limit.Rate, err = parseRate(config.MaxRate)
+	if err != nil {
+		return limit, karma.
+			Describe("max_rate", config.MaxRate).
+			Format(
+				err,
+				"unable to parse max rate",
+			)
+	}
+
+	for _, window := range config.RateWindows {
+		facts := karma.
+			Describe("from", window.From).
+			Describe("to", window.To).
+			Describe("rate", window.Rate)
+
+		var parsed zfs.RateWindow
+
+		parsed.From, err = parseTimeOfDay(window.From)
+		if err != nil {
+			return limit, facts.Format(err, "invalid rate window start")
+		}
+
+		parsed.To, err = parseTimeOfDay(window.To)
+		if err != nil {
+			return limit, facts.Format(err, "invalid rate window end")
+		}
+
+		parsed.Rate, err = parseRate(window.Rate)
+		if err != nil {
+			return limit, facts.Format(err, "invalid rate window rate")
+		}
+
+		limit.Windows = append(limit.Windows, parsed)
+	}
+
+	return limit, nil
+}
+
+// parseRate parses rate in bytes per second like 50M, empty value or
+// 'unlimited' mean that rate is not limited.
+func parseRate(value string) (uint64, error) {
+	if value == "" || value == rateUnlimited {
+		return 0, nil
+	}
+
+	return formatting.ParseSize(value)
+}
+
+// parseTimeOfDay parses time like 01:30 into offset since midnight.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	matches := reTimeOfDay.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, fmt.Errorf("expected time of day like 01:30")
+	}
+
+	hours, _ := strconv.Atoi(matches[1])
+	minutes, _ := strconv.Atoi(matches[2])
+
+	if hours > 24 || minutes > 59 || (hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("time of day is out of range")
+	}
+
+	return time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute, nil
+}
+
+// getBufferSize returns size of in-memory buffer between zfs send and zfs
+// recv.
+func getBufferSize(config *config.Config) (uint64, error) {
+	size, err := formatting.ParseSize(config.BufferSize)
+	if err != nil {
+		return 0, karma.
+			Describe("buffer_size", config.BufferSize).
+			Format(
+				err,
+				"unable to parse stream buffer size",
+			)
+	}
+
+	return size, nil
+}
+
+// getDefaultFlags returns zfs send and zfs recv flags configured in defaults
+// section, they can be overriden by dataset properties.
+func getDefaultFlags(config *config.Config) (operation.Flags, error) {
+	var (
+		flags    operation.Flags
+		defaults = config.Defaults
+		err      error
+	)
+
+	flags.Send, err = zfs.ParseSendFlags(defaults.Send.Flags)
+	if err != nil {
+		return flags, karma.
+			Describe("flags", defaults.Send.Flags).
+			Format(
+				err,
+				"invalid default send flags",
+			)
+	}
+
+	flags.RecvExclude, err = zfs.ParseRecvExcludeProperties(
+		defaults.Recv.ExcludeProperties,
+	)
+	if err != nil {
+		return flags, karma.
+			Describe("exclude_properties", defaults.Recv.ExcludeProperties).
+			Format(
+				err,
+				"invalid default receive excluded properties",
+			)
+	}
+
+	flags.RecvSet, err = zfs.ParseRecvSetProperties(
+		defaults.Recv.SetProperties,
+	)
+	if err != nil {
+		return flags, karma.
+			Describe("set_properties", defaults.Recv.SetProperties).
+			Format(
+				err,
+				"invalid default receive properties",
+			)
+	}
+
+	return flags, nil
+}
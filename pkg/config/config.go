@@ -7,14 +7,76 @@ import (
 	"github.com/reconquest/karma-go"
 )
 
+const (
+	// TargetModeRemovable is used for pools which are attached only for
+	// backup, so they are imported before backup and exported afterwards.
+	TargetModeRemovable = "removable"
+
+	// TargetModePermanent is used for pools which are always imported.
+	TargetModePermanent = "permanent"
+)
+
+const (
+	// TargetSelectionFirst makes backup to the first available target.
+	TargetSelectionFirst = "first"
+
+	// TargetSelectionAll makes backup to every available target.
+	TargetSelectionAll = "all"
+)
+
+const (
+	// OnInsufficientSpaceFail makes backup to fail before sending anything if
+	// estimated size of all streams exceeds space available on target pool.
+	OnInsufficientSpaceFail = "fail"
+
+	// OnInsufficientSpaceSkip makes backup to skip the largest datasets until
+	// the rest of them fits into target pool.
+	OnInsufficientSpaceSkip = "skip"
+
+	// OnInsufficientSpaceHousekeeping makes backup to destroy the oldest
+	// snapshots on target pool until estimated streams fit into it.
+	OnInsufficientSpaceHousekeeping = "housekeeping"
+
+	// OnInsufficientSpaceIgnore disables the check.
+	OnInsufficientSpaceIgnore = "ignore"
+)
+
 type Config struct {
 	TargetPool    string `toml:"target_pool" default:"zbackup"`
 	TargetDataset string `toml:"target_dataset" default:"$HOSTNAME"`
+	TargetMode    string `toml:"target_mode" default:"removable"`
+
+	Targets         []Target `toml:"targets"`
+	TargetSelection string   `toml:"target_selection" default:"first"`
+
+	Remote struct {
+		Host         string `toml:"host"`
+		User         string `toml:"user" default:"root"`
+		Port         int    `toml:"port" default:"22"`
+		IdentityFile string `toml:"identity_file"`
+		ManagePool   bool   `toml:"manage_pool" default:"false"`
+		Compression  string `toml:"compression" default:"none"`
+		Zeus         string `toml:"zeus" default:"zeusd"`
+	} `toml:"remote"`
+
+	Status struct {
+		MaxAge string `toml:"max_age" default:"1d"`
+	} `toml:"status"`
 
 	SnapshotPrefix string `toml:"snapshot_prefix" default:"'zeus:'"`
 
 	HoldTag string `toml:"hold_tag" default:"zeus" required:"true"`
 
+	Concurrency int  `toml:"concurrency" default:"1"`
+	FailFast    bool `toml:"fail_fast" default:"false"`
+
+	MaxRate     string       `toml:"max_rate" default:"unlimited"`
+	RateWindows []RateWindow `toml:"rate_windows"`
+
+	BufferSize string `toml:"buffer_size" default:"0"`
+
+	OnInsufficientSpace string `toml:"on_insufficient_space" default:"fail"`
+
 	EncryptionKey struct {
 		Provider string `toml:"provider" default:"command"`
 
@@ -29,15 +91,115 @@ type Config struct {
 				KeepOnTarget int `toml:"keep_on_target" default:"10"`
 				KeepOnSource int `toml:"keep_on_source" default:"1"`
 			} `toml:"by_count"`
+
+			ByAge struct {
+				KeepOnSource int    `toml:"keep_on_source" default:"1"`
+				KeepAll      string `toml:"keep_all" default:"24h"`
+				KeepDaily    int    `toml:"keep_daily" default:"14"`
+				KeepWeekly   int    `toml:"keep_weekly" default:"8"`
+				KeepMonthly  int    `toml:"keep_monthly" default:"12"`
+			} `toml:"by_age"`
 		} `toml:"housekeeping"`
+
+		Send struct {
+			Flags string `toml:"flags"`
+		} `toml:"send"`
+
+		Recv struct {
+			ExcludeProperties string `toml:"exclude_properties"`
+			SetProperties     string `toml:"set_properties"`
+		} `toml:"recv"`
 	} `toml:"defaults"`
 }
 
+// Target describes one of backup pools which are used in rotation.
+type Target struct {
+	Pool    string `toml:"pool"`
+	Dataset string `toml:"dataset"`
+	HoldTag string `toml:"hold_tag"`
+}
+
+// RateWindow overrides max_rate between given times of day, e.g. to make
+// backup unlimited at night.
+type RateWindow struct {
+	From string `toml:"from"`
+	To   string `toml:"to"`
+	Rate string `toml:"rate"`
+}
+
 type EncryptionKeyCommand struct {
 	Executable string   `toml:"executable" default:"zfs-encryption-key"`
 	Args       []string `toml:"args" default:"['$DATASET']"`
 }
 
+// IsTargetPermanent reports whether target pool is always imported, so zeus
+// should neither import nor export it.
+func (config *Config) IsTargetPermanent() bool {
+	return config.TargetMode == TargetModePermanent
+}
+
+// IsSpaceChecked reports whether estimated size of streams should be checked
+// against space available on target pool before backup.
+func (config *Config) IsSpaceChecked() bool {
+	return config.OnInsufficientSpace != OnInsufficientSpaceIgnore
+}
+
+// GetTargets returns configured backup targets. If no targets are configured,
+// single target specified by target_pool, target_dataset and hold_tag is
+// returned.
+func (config *Config) GetTargets() []Target {
+	if len(config.Targets) == 0 {
+		return []Target{
+			{
+				Pool:    config.TargetPool,
+				Dataset: config.TargetDataset,
+				HoldTag: config.HoldTag,
+			},
+		}
+	}
+
+	return config.Targets
+}
+
+// IsTargetRotated reports whether backup is made only to the first available
+// target, so targets are used in rotation.
+func (config *Config) IsTargetRotated() bool {
+	return config.TargetSelection == TargetSelectionFirst
+}
+
+// WithTarget returns copy of the config which uses given target as target
+// pool, dataset and hold tag.
+func (config *Config) WithTarget(target Target) *Config {
+	copied := *config
+
+	copied.TargetPool = target.Pool
+	copied.TargetDataset = target.Dataset
+	copied.HoldTag = target.HoldTag
+
+	return &copied
+}
+
+// SelectTargets returns targets which pools are reported as available by
+// given function, either all of them or only the first one depending on
+// target_selection.
+func (config *Config) SelectTargets(available func(pool string) bool) []Target {
+	selected := []Target{}
+
+	for _, target := range config.GetTargets() {
+		if !available(target.Pool) {
+			continue
+		}
+
+		selected = append(selected, target)
+
+		if config.TargetSelection == TargetSelectionFirst {
+			break
+		}
+	}
+
+	return selected
+}
+
 func LoadConfig(path string) (*Config, error) {
 	config := &Config{}
 	err := ko.Load(path, config, ko.RequireFile(false))
@@ -57,5 +219,105 @@ func LoadConfig(path string) (*Config, error) {
 		config.TargetDataset = hostname
 	}
 
+	err = prepareTargets(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Concurrency < 1 {
+		return nil, karma.
+			Describe("concurrency", config.Concurrency).
+			Reason("concurrency should be at least 1")
+	}
+
+	switch config.TargetSelection {
+	case TargetSelectionFirst, TargetSelectionAll:
+		// ok
+	default:
+		return nil, karma.
+			Describe("target_selection", config.TargetSelection).
+			Reason(
+				"unsupported target selection, supported values are: " +
+					"'first', 'all'",
+			)
+	}
+
+	switch config.OnInsufficientSpace {
+	case OnInsufficientSpaceFail,
+		OnInsufficientSpaceSkip,
+		OnInsufficientSpaceHousekeeping,
+		OnInsufficientSpaceIgnore:
+		// ok
+	default:
+		return nil, karma.
+			Describe("on_insufficient_space", config.OnInsufficientSpace).
+			Reason(
+				"unsupported value, supported values are: " +
+					"'fail', 'skip', 'housekeeping', 'ignore'",
+			)
+	}
+
+	switch config.TargetMode {
+	case TargetModeRemovable, TargetModePermanent:
+		// ok
+	default:
+		return nil, karma.
+			Describe("target_mode", config.TargetMode).
+			Reason(
+				"unsupported target mode, supported modes are: " +
+					"'removable', 'permanent'",
+			)
+	}
+
 	return config, nil
 }
+
+// prepareTargets fills target settings which are not specified with top-level
+// ones. Every target gets distinct hold tag by default, so snapshots required
+// for incremental backup to one target are not released by backup to
+// another.
+func prepareTargets(config *Config) error {
+	var (
+		pools = map[string]bool{}
+		tags  = map[string]bool{}
+	)
+
+	for i := range config.Targets {
+		target := &config.Targets[i]
+
+		if target.Pool == "" {
+			return karma.
+				Describe("target", i+1).
+				Reason("target pool is not specified")
+		}
+
+		if target.Dataset == "" || target.Dataset == "$HOSTNAME" {
+			target.Dataset = config.TargetDataset
+		}
+
+		if target.HoldTag == "" {
+			target.HoldTag = config.HoldTag
+
+			if len(config.Targets) > 1 {
+				target.HoldTag = config.HoldTag + ":" + target.Pool
+			}
+		}
+
+		if pools[target.Pool] {
+			return karma.
+				Describe("pool", target.Pool).
+				Reason("target pool is specified more than once")
+		}
+
+		if tags[target.HoldTag] {
+			return karma.
+				Describe("hold_tag", target.HoldTag).
+				Reason("hold tag is used by more than one target")
+		}
+
+		pools[target.Pool] = true
+		tags[target.HoldTag] = true
+	}
+
+	return nil
+}
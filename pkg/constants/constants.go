@@ -10,14 +10,49 @@ const (
 	Referenced         = "referenced"
 	Used               = "used"
 	Written            = "written"
+	UserRefs           = "userrefs"
+	ReceiveResumeToken = "receive_resume_token"
+	Creation           = "creation"
+	Health             = "health"
+	Capacity           = "capacity"
+	Free               = "free"
+	CreateTXG          = "createtxg"
+	Type               = "type"
+	TypeVolume         = "volume"
+	Volsize            = "volsize"
+	Volmode            = "volmode"
+	Mountpoint         = "mountpoint"
+	Available          = "available"
 )
 
 const (
 	Managed = "zeus::managed"
 
+	// Raw marks target snapshots which have been received from raw send.
+	Raw = "zeus::raw"
+
+	// Recursive marks target snapshots which have been received from
+	// replication stream along with snapshots of descendants.
+	Recursive = "zeus::recursive"
+
+	// TargetPrefix is followed by guid of target backup pool, property
+	// marks source snapshots which have been sent to that pool.
+	TargetPrefix = "zeus::target:"
+
 	Backup                          = "zeus:backup"
 	BackupInterval                  = "zeus:backup:interval"
+	BackupRateLimit                 = "zeus:backup:rate-limit"
+	BackupRaw                       = "zeus:backup:raw"
+	BackupOnBrokenChain             = "zeus:backup:on-broken-chain"
+	SendFlags                       = "zeus:send:flags"
+	RecvExcludeProperties           = "zeus:recv:exclude-properties"
+	RecvSetProperties               = "zeus:recv:set-properties"
 	Housekeeping                    = "zeus:housekeeping"
 	HousekeepingByCountKeepOnTarget = "zeus:housekeeping:by-count:keep-on-target"
 	HousekeepingByCountKeepOnSource = "zeus:housekeeping:by-count:keep-on-source"
+	HousekeepingByAgeKeepOnSource   = "zeus:housekeeping:by-age:keep-on-source"
+	HousekeepingByAgeKeepAll        = "zeus:housekeeping:by-age:keep-all"
+	HousekeepingByAgeKeepDaily      = "zeus:housekeeping:by-age:keep-daily"
+	HousekeepingByAgeKeepWeekly     = "zeus:housekeeping:by-age:keep-weekly"
+	HousekeepingByAgeKeepMonthly    = "zeus:housekeeping:by-age:keep-monthly"
 )
@@ -0,0 +1,45 @@
+package duration
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	reDays = regexp.MustCompile(`^(\d+)([dw])$`)
+)
+
+// Parse parses duration in format of time.ParseDuration with additional
+// support for days (1d) and weeks (1w).
+func Parse(value string) (time.Duration, error) {
+	var duration time.Duration
+
+	if matches := reDays.FindStringSubmatch(value); matches != nil {
+		count, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return 0, err
+		}
+
+		switch matches[2] {
+		case "d":
+			duration = time.Duration(count) * 24 * time.Hour
+		case "w":
+			duration = time.Duration(count) * 7 * 24 * time.Hour
+		}
+	} else {
+		var err error
+
+		duration, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if duration < 0 {
+		return 0, fmt.Errorf("duration should not be negative")
+	}
+
+	return duration, nil
+}
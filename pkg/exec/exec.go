@@ -38,29 +38,29 @@ func Exec(command string, args ...string) *Execution {
 	}
 }
 
-func getLogger(log *lorg.Log) lexec.Logger {
-	formatShellCommand := func(command []string) string {
-		var (
-			reSpecialChars       = regexp.MustCompile("[$`\"!']")
-			reSpecialCharsEscape = regexp.MustCompile("[$`\"!]")
-		)
+func formatShellCommand(command []string) string {
+	var (
+		reSpecialChars       = regexp.MustCompile("[$`\"!']")
+		reSpecialCharsEscape = regexp.MustCompile("[$`\"!]")
+	)
 
-		var safe []string
+	var safe []string
 
-		for _, arg := range command {
-			if reSpecialChars.MatchString(arg) {
-				safe = append(safe, fmt.Sprintf(
-					`"%s"`,
-					reSpecialCharsEscape.ReplaceAllString(arg, `\$0`),
-				))
-			} else {
-				safe = append(safe, arg)
-			}
+	for _, arg := range command {
+		if reSpecialChars.MatchString(arg) {
+			safe = append(safe, fmt.Sprintf(
+				`"%s"`,
+				reSpecialCharsEscape.ReplaceAllString(arg, `\$0`),
+			))
+		} else {
+			safe = append(safe, arg)
 		}
-
-		return strings.Join(safe, " ")
 	}
 
+	return strings.Join(safe, " ")
+}
+
+func getLogger(log *lorg.Log) lexec.Logger {
 	return func(command []string, stream lexec.Stream, data []byte) {
 		if stream == lexec.InternalDebug {
 			log.Tracef(
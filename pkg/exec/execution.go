@@ -12,6 +12,9 @@ type Execution struct {
 
 	stdout io.Writer
 	stderr io.Writer
+
+	// planned execution is recorded in dry run mode and never started
+	planned bool
 }
 
 func (execution *Execution) Cached() *ExecutionCached {
@@ -20,7 +23,17 @@ func (execution *Execution) Cached() *ExecutionCached {
 	}
 }
 
+// IsPlanned reports whether execution has been recorded into the dry run
+// plan instead of being executed.
+func (execution *Execution) IsPlanned() bool {
+	return execution.planned
+}
+
 func (execution *Execution) Output() (string, string, error) {
+	if execution.planned {
+		return "", "", nil
+	}
+
 	stdout, stderr, err := execution.Execution.Output()
 	if err != nil {
 		return string(stdout), string(stderr), karma.Format(
@@ -32,17 +45,29 @@ func (execution *Execution) Output() (string, string, error) {
 	return string(stdout), string(stderr), nil
 }
 
-//func (execution *Execution) Run() error {
-//    err := execution.Execution.Run()
-//    if err != nil {
-//        return karma.Format(
-//            err,
-//            "unable to run command",
-//        )
-//    }
+func (execution *Execution) Run() error {
+	if execution.planned {
+		return nil
+	}
+
+	return execution.Execution.Run()
+}
 
-//    return nil
-//}
+func (execution *Execution) Start() error {
+	if execution.planned {
+		return nil
+	}
+
+	return execution.Execution.Start()
+}
+
+func (execution *Execution) Wait() error {
+	if execution.planned {
+		return nil
+	}
+
+	return execution.Execution.Wait()
+}
 
 func (execution *Execution) SetStdout(writer io.Writer) *Execution {
 	execution.stdout = writer
@@ -0,0 +1,123 @@
+package exec
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/reconquest/lexec-go"
+)
+
+// PlannedCommand is mutating command which has not been executed because of
+// dry run mode.
+type PlannedCommand struct {
+	Transport string
+	Command   []string
+}
+
+var (
+	dryRun    bool
+	plan      []PlannedCommand
+	planMutex sync.Mutex
+)
+
+// SetDryRun enables dry run mode: commands which modify zfs state are
+// recorded into the plan instead of being executed, while read-only commands
+// are still executed.
+func SetDryRun(enabled bool) {
+	dryRun = enabled
+}
+
+func IsDryRun() bool {
+	return dryRun
+}
+
+// GetPlan returns mutating commands recorded in dry run mode in order of
+// their invocation.
+func GetPlan() []PlannedCommand {
+	planMutex.Lock()
+	defer planMutex.Unlock()
+
+	result := make([]PlannedCommand, len(plan))
+	copy(result, plan)
+
+	return result
+}
+
+// Plan records given command into the plan without executing it.
+func Plan(transport Transport, command string, args ...string) {
+	planMutex.Lock()
+	defer planMutex.Unlock()
+
+	plan = append(plan, PlannedCommand{
+		Transport: transport.String(),
+		Command:   append([]string{command}, args...),
+	})
+}
+
+func (command PlannedCommand) String() string {
+	return fmt.Sprintf(
+		"[%s] %s",
+		command.Transport,
+		formatShellCommand(command.Command),
+	)
+}
+
+// isMutating reports whether given command changes state of zfs pools or
+// datasets. Commands which are not zfs or zpool invocations, like encryption
+// key provider, are never considered mutating.
+func isMutating(command string, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch command {
+	case `zfs`:
+		switch args[0] {
+		case `get`, `list`, `holds`, `version`:
+			return false
+		case `send`:
+			for _, arg := range args[1:] {
+				if strings.HasPrefix(arg, `-`) &&
+					!strings.HasPrefix(arg, `--`) &&
+					strings.Contains(arg, `n`) {
+					return false
+				}
+			}
+		}
+
+		return true
+
+	case `zpool`:
+		switch args[0] {
+		case `get`, `list`, `status`:
+			return false
+		case `import`:
+			// without pool name zpool import only lists pools available
+			// for import
+			return len(args) > 1
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func execPlanned(
+	transport Transport,
+	command string,
+	args ...string,
+) *Execution {
+	Plan(transport, command, args...)
+
+	return &Execution{
+		Execution: lexec.NewExec(
+			getLogger(logger.NewChildWithPrefix("<plan>")),
+			exec.Command(command, args...),
+		),
+
+		planned: true,
+	}
+}
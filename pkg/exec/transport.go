@@ -0,0 +1,74 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transport specifies where commands will be executed.
+type Transport interface {
+	Exec(command string, args ...string) *Execution
+	String() string
+}
+
+type LocalTransport struct{}
+
+type SSHTransport struct {
+	Host         string
+	User         string
+	Port         int
+	IdentityFile string
+}
+
+func (transport LocalTransport) Exec(
+	command string,
+	args ...string,
+) *Execution {
+	if dryRun && isMutating(command, args) {
+		return execPlanned(transport, command, args...)
+	}
+
+	return Exec(command, args...)
+}
+
+func (LocalTransport) String() string {
+	return "local"
+}
+
+func (transport SSHTransport) Exec(command string, args ...string) *Execution {
+	if dryRun && isMutating(command, args) {
+		return execPlanned(transport, command, args...)
+	}
+
+	sshArgs := []string{`-o`, `BatchMode=yes`}
+
+	if transport.Port != 0 {
+		sshArgs = append(sshArgs, `-p`, fmt.Sprint(transport.Port))
+	}
+
+	if transport.IdentityFile != "" {
+		sshArgs = append(sshArgs, `-i`, transport.IdentityFile)
+	}
+
+	sshArgs = append(sshArgs, transport.String(), `--`, quoteShell(command))
+
+	// ssh joins all arguments into single string which is then interpreted
+	// by remote shell, so every argument should be quoted
+	for _, arg := range args {
+		sshArgs = append(sshArgs, quoteShell(arg))
+	}
+
+	return Exec(`ssh`, sshArgs...)
+}
+
+func (transport SSHTransport) String() string {
+	if transport.User == "" {
+		return transport.Host
+	}
+
+	return transport.User + "@" + transport.Host
+}
+
+func quoteShell(arg string) string {
+	return `'` + strings.ReplaceAll(arg, `'`, `'\''`) + `'`
+}
@@ -1,6 +1,12 @@
 package formatting
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
 
 func Size(size uint64) string {
 	const unit = 1000
@@ -17,3 +23,33 @@ func Size(size uint64) string {
 	return fmt.Sprintf("%.1f %cB",
 		float64(size)/float64(div), "kMGTPE"[exp])
 }
+
+var (
+	reSize = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*(?:([kKMGTPE])i?)?B?$`)
+)
+
+// ParseSize parses size formatted like 500, 50M or 1.5G. Units are binary
+// like ones used by zfs, so 50M is 50 MiB, 50MiB is accepted as well.
+func ParseSize(value string) (uint64, error) {
+	matches := reSize.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil {
+		return 0, fmt.Errorf(
+			"invalid size %q, expected number with optional unit "+
+				"suffix like 50M",
+			value,
+		)
+	}
+
+	size, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if matches[2] != "" {
+		exp := strings.Index("KMGTPE", strings.ToUpper(matches[2]))
+
+		size *= math.Pow(1024, float64(exp+1))
+	}
+
+	return uint64(size), nil
+}
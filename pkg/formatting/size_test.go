@@ -0,0 +1,49 @@
+package formatting
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected uint64
+		fail     bool
+	}{
+		{value: "0", expected: 0},
+		{value: "500", expected: 500},
+		{value: "1k", expected: 1024},
+		{value: "50M", expected: 50 * 1024 * 1024},
+		{value: "50MiB", expected: 50 * 1024 * 1024},
+		{value: "50MB", expected: 50 * 1024 * 1024},
+		{value: "1.5G", expected: 1536 * 1024 * 1024},
+		{value: " 2 T ", expected: 2 << 40},
+		{value: "50X", fail: true},
+		{value: "M", fail: true},
+		{value: "-1", fail: true},
+	}
+
+	for _, testcase := range testcases {
+		size, err := ParseSize(testcase.value)
+		if testcase.fail {
+			if err == nil {
+				t.Errorf("%q: expected error, got %d", testcase.value, size)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %s", testcase.value, err)
+
+			continue
+		}
+
+		if size != testcase.expected {
+			t.Errorf(
+				"%q: expected %d, got %d",
+				testcase.value,
+				testcase.expected,
+				size,
+			)
+		}
+	}
+}
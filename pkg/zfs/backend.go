@@ -0,0 +1,69 @@
+package zfs
+
+import (
+	"io"
+)
+
+// Backend performs operations on pools and datasets. Host, which runs zfs
+// and zpool commands, is the default implementation.
+type Backend interface {
+	// IsLocal reports whether pools are attached to the machine zeus is
+	// running on.
+	IsLocal() bool
+	String() string
+
+	GetImportList() ([]string, error)
+	GetImportedPools() ([]string, error)
+	ImportPool(name string) error
+	ExportPool(name string) error
+
+	GetPoolProperties(
+		requests []PropertyRequest,
+		pools ...string,
+	) ([]PropertyMapping, error)
+	GetDatasetProperties(
+		requests []PropertyRequest,
+		datasets ...string,
+	) ([]PropertyMapping, error)
+	GetDatasetPropertiesRecursive(
+		requests []PropertyRequest,
+		datasets ...string,
+	) ([]PropertyMapping, error)
+	SetDatasetProperty(dataset string, name string, value string) error
+
+	EnsureDatasetExists(dataset string) error
+	IsDatasetExists(dataset string) (bool, error)
+	DestroyDataset(name string) error
+
+	CreateSnapshot(snapshot string) error
+	CreateSnapshotRecursive(snapshot string) error
+	ListSnapshots(dataset string) ([]string, error)
+
+	Hold(tag string, snapshot string) error
+	Release(tag string, snapshot string) error
+	ListHolds(snapshot string) ([]string, error)
+	HasHold(tag string, snapshot string) (bool, error)
+
+	LoadKey(dataset string, key string) error
+	UnloadKey(dataset string) error
+
+	// Send starts zfs send with given flags and returns stream of its output.
+	// Closing the stream waits for zfs send to finish. Progress function is
+	// called with progress reported by zfs send itself.
+	Send(progress func(SendProgress), args ...string) (io.ReadCloser, error)
+	// Receive runs zfs recv with given flags reading stream made by Send.
+	Receive(stream io.Reader, args ...string) error
+	// ReceiveCompressed is the same as Receive, but stream is compressed
+	// with given compression.
+	ReceiveCompressed(
+		stream io.Reader,
+		compression Compression,
+		args ...string,
+	) error
+	EstimateSend(args ...string) (SendEstimate, error)
+	GetResumeToken(dataset string) (string, error)
+}
+
+var (
+	_ Backend = (*Host)(nil)
+)
@@ -0,0 +1,138 @@
+package zfs
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	errBufferClosed = errors.New("stream buffer is closed by reader")
+)
+
+// ringBuffer is an in-memory buffer between zfs send and zfs recv, which
+// allows send to proceed while recv is stalled and vice versa.
+type ringBuffer struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+
+	data  []byte
+	start int
+	size  int
+
+	// err is returned to reader once buffer is drained, it's io.EOF if
+	// writer has finished successfully
+	err    error
+	closed bool
+}
+
+func newRingBuffer(size uint64) *ringBuffer {
+	buffer := &ringBuffer{
+		data: make([]byte, size),
+	}
+
+	buffer.cond = sync.NewCond(&buffer.mutex)
+
+	return buffer
+}
+
+func (buffer *ringBuffer) Write(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	var written int
+
+	for written < len(data) {
+		for buffer.size == len(buffer.data) && !buffer.closed {
+			buffer.cond.Wait()
+		}
+
+		if buffer.closed {
+			return written, errBufferClosed
+		}
+
+		end := (buffer.start + buffer.size) % len(buffer.data)
+
+		free := len(buffer.data) - buffer.size
+		if end+free > len(buffer.data) {
+			free = len(buffer.data) - end
+		}
+
+		size := copy(buffer.data[end:end+free], data[written:])
+
+		buffer.size += size
+		written += size
+
+		buffer.cond.Broadcast()
+	}
+
+	return written, nil
+}
+
+func (buffer *ringBuffer) Read(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	for buffer.size == 0 && buffer.err == nil && !buffer.closed {
+		buffer.cond.Wait()
+	}
+
+	if buffer.closed {
+		return 0, errBufferClosed
+	}
+
+	if buffer.size == 0 {
+		return 0, buffer.err
+	}
+
+	size := buffer.size
+	if buffer.start+size > len(buffer.data) {
+		size = len(buffer.data) - buffer.start
+	}
+
+	size = copy(data, buffer.data[buffer.start:buffer.start+size])
+
+	buffer.start = (buffer.start + size) % len(buffer.data)
+	buffer.size -= size
+
+	buffer.cond.Broadcast()
+
+	return size, nil
+}
+
+// CloseWithError is called by writer when there is no more data to write,
+// reader gets given error or io.EOF if it's nil after reading remaining
+// data.
+func (buffer *ringBuffer) CloseWithError(err error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	if err == nil {
+		err = io.EOF
+	}
+
+	buffer.err = err
+
+	buffer.cond.Broadcast()
+}
+
+// Close is called by reader when it doesn't need more data, so blocked
+// writer is released.
+func (buffer *ringBuffer) Close() error {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	buffer.closed = true
+
+	buffer.cond.Broadcast()
+
+	return nil
+}
+
+// Len returns amount of buffered data.
+func (buffer *ringBuffer) Len() uint64 {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return uint64(buffer.size)
+}
@@ -0,0 +1,116 @@
+package zfs
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compression is an algorithm used to compress send stream on its way to
+// remote target.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionZstd Compression = "zstd"
+	CompressionLZ4  Compression = "lz4"
+)
+
+// ParseCompression checks that given compression is supported.
+func ParseCompression(value string) (Compression, error) {
+	switch compression := Compression(value); compression {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionZstd, CompressionLZ4:
+		return compression, nil
+	}
+
+	return CompressionNone, fmt.Errorf(
+		"unsupported compression %q, supported are: 'none', 'zstd', 'lz4'",
+		value,
+	)
+}
+
+type compressedStream struct {
+	*io.PipeReader
+
+	done chan struct{}
+}
+
+// Close stops compression and waits until source stream is not read
+// anymore, so it can be closed.
+func (stream compressedStream) Close() error {
+	stream.PipeReader.Close()
+
+	<-stream.done
+
+	return nil
+}
+
+// compress returns reader of compressed stream, stream is read and
+// compressed in background.
+func compress(reader io.Reader, compression Compression) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var (
+			writer io.WriteCloser
+			err    error
+		)
+
+		switch compression {
+		case CompressionZstd:
+			writer, err = zstd.NewWriter(pipeWriter)
+		case CompressionLZ4:
+			writer = lz4.NewWriter(pipeWriter)
+		default:
+			err = fmt.Errorf("unsupported compression %q", compression)
+		}
+
+		if err == nil {
+			_, err = io.Copy(writer, reader)
+
+			closeErr := writer.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}
+
+		pipeWriter.CloseWithError(err)
+	}()
+
+	return compressedStream{
+		PipeReader: pipeReader,
+		done:       done,
+	}
+}
+
+// Decompress returns reader of decompressed stream.
+func Decompress(
+	reader io.Reader,
+	compression Compression,
+) (io.ReadCloser, error) {
+	switch compression {
+	case "", CompressionNone:
+		return io.NopCloser(reader), nil
+
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		return decoder.IOReadCloser(), nil
+
+	case CompressionLZ4:
+		return io.NopCloser(lz4.NewReader(reader)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported compression %q", compression)
+}
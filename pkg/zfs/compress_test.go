@@ -0,0 +1,80 @@
+package zfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("zfs send stream "), 64*1024)
+
+	for _, compression := range []Compression{
+		CompressionZstd,
+		CompressionLZ4,
+	} {
+		t.Run(string(compression), func(t *testing.T) {
+			compressed, err := io.ReadAll(
+				compress(bytes.NewReader(data), compression),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(compressed) >= len(data) {
+				t.Errorf(
+					"stream is not compressed: %d >= %d",
+					len(compressed),
+					len(data),
+				)
+			}
+
+			decompressed, err := Decompress(
+				bytes.NewReader(compressed),
+				compression,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer decompressed.Close()
+
+			result, err := io.ReadAll(decompressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(result, data) {
+				t.Error("decompressed stream doesn't match original one")
+			}
+		})
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for value, expected := range map[string]Compression{
+		"":     CompressionNone,
+		"none": CompressionNone,
+		"zstd": CompressionZstd,
+		"lz4":  CompressionLZ4,
+	} {
+		compression, err := ParseCompression(value)
+		if err != nil {
+			t.Errorf("ParseCompression(%q): %s", value, err)
+		}
+
+		if compression != expected {
+			t.Errorf(
+				"ParseCompression(%q) = %q, expected %q",
+				value,
+				compression,
+				expected,
+			)
+		}
+	}
+
+	_, err := ParseCompression("gzip")
+	if err == nil {
+		t.Error("expected unsupported compression to be rejected")
+	}
+}
@@ -1,130 +1,405 @@
 package zfs
 
 import (
-	"fmt"
 	"io"
-	"strconv"
+	"sync"
 	"time"
 
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/zeus/pkg/constants"
 	"github.com/reconquest/zeus/pkg/exec"
 	"github.com/reconquest/zeus/pkg/log"
 )
 
-type CopyProgress struct {
-	StartedAt time.Time
+type (
+	CopyProgress struct {
+		StartedAt time.Time
+
+		// TotalSize is estimated size of the stream, SentSize is amount of
+		// data sent so far as reported by zfs send -v -P once a second.
+		TotalSize uint64
+		SentSize  uint64
+		Sent      bool
+
+		// Snapshot and SnapshotSent are the snapshot which is being sent and
+		// amount of its data sent so far as reported by zfs send itself,
+		// they differ from SentSize for replication streams, which contain
+		// several snapshots. They are empty until zfs send reports progress.
+		Snapshot     string
+		SnapshotSent uint64
+
+		// RateLimit is a rate limit effective at the moment, zero if rate
+		// is not limited.
+		RateLimit uint64
+
+		// BufferUsed is amount of data in the stream buffer, BufferSize is
+		// zero if stream is not buffered.
+		BufferUsed uint64
+		BufferSize uint64
+	}
 
-	TotalSize uint64
-	SentSize  uint64
-	Sent      bool
-}
+	CopyOpts interface{}
+
+	// CopyOptForce makes zfs recv to rollback or overwrite target dataset
+	// if it has been modified or already exists.
+	CopyOptForce bool
+
+	// CopyOptRateLimit limits rate of the send stream.
+	CopyOptRateLimit RateLimit
+
+	// CopyOptRateLimiter limits total rate of send streams which share the
+	// same limiter.
+	CopyOptRateLimiter *RateLimiter
+
+	// CopyOptBufferSize specifies size of in-memory buffer between zfs send
+	// and zfs recv, stream is not buffered if it's zero.
+	CopyOptBufferSize uint64
+
+	// CopyOptCompression specifies compression of the stream sent to remote
+	// target, it's ignored for local targets.
+	CopyOptCompression Compression
+
+	// CopyOptRaw makes zfs send to send encrypted dataset as is, so
+	// received dataset is encrypted with the same key.
+	CopyOptRaw bool
+
+	// CopyOptSendFlags specifies additional zfs send flags, they are not
+	// used for resumed send since they are stored in resume token.
+	CopyOptSendFlags []string
 
+	// CopyOptRecvFlags specifies additional zfs recv flags.
+	CopyOptRecvFlags []string
+
+	// CopyOptRecursive makes zfs send to send replication stream of the
+	// snapshot and snapshots with the same name of all descendants, target
+	// dataset should be a filesystem then. Replication stream can't be
+	// resumed, so it's received without -s.
+	CopyOptRecursive bool
+)
+
+// CopyDataset sends given source snapshot from the source host and receives
+// it into target dataset, which is used as is, on the target host.
 func CopyDataset(
+	source Backend,
+	target Backend,
 	sourceSnapshot string,
 	targetDataset string,
 	baseSnapshot string,
 	progressFunc func(CopyProgress),
+	opts ...CopyOpts,
 ) error {
-	sendArgs := []string{
-		`send`, `-P`, `-c`, sourceSnapshot,
+	var totalSize uint64
+
+	// snapshot to send is not created in dry run mode, so its size is
+	// unknown
+	if !exec.IsDryRun() {
+		var err error
+
+		totalSize, err = EstimateCopyDataset(
+			source,
+			sourceSnapshot,
+			baseSnapshot,
+			opts...,
+		)
+		if err != nil {
+			return err
+		}
 	}
 
+	return copyDataset(
+		source,
+		target,
+		getSendArgs(sourceSnapshot, baseSnapshot, opts...),
+		targetDataset,
+		totalSize,
+		progressFunc,
+		opts...,
+	)
+}
+
+// EstimateCopyDataset returns size of the stream which would be sent by
+// CopyDataset with the same arguments as estimated by zfs send itself.
+func EstimateCopyDataset(
+	source Backend,
+	sourceSnapshot string,
+	baseSnapshot string,
+	opts ...CopyOpts,
+) (uint64, error) {
+	sendArgs := getSendArgs(sourceSnapshot, baseSnapshot, opts...)
+
+	estimate, err := source.EstimateSend(sendArgs...)
+	if err != nil {
+		return 0, karma.Format(
+			err,
+			"unable to estimate size of send stream",
+		)
+	}
+
+	return estimate.Size, nil
+}
+
+// getSendArgs returns zfs send arguments for given snapshots, they are the
+// same for estimate and for actual send, so estimate takes compression and
+// replication stream into account.
+func getSendArgs(
+	sourceSnapshot string,
+	baseSnapshot string,
+	opts ...CopyOpts,
+) []string {
+	var (
+		mode      = `-c`
+		sendFlags []string
+		recursive bool
+	)
+
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case CopyOptRaw:
+			// raw stream contains blocks as they are stored on disk, so
+			// they are sent compressed and encrypted
+			if opt {
+				mode = `-w`
+			}
+		case CopyOptSendFlags:
+			sendFlags = opt
+		case CopyOptRecursive:
+			recursive = bool(opt)
+		}
+	}
+
+	sendArgs := []string{mode}
+
+	if recursive {
+		sendArgs = append(sendArgs, `-R`)
+	}
+
+	sendArgs = append(sendArgs, sendFlags...)
+	sendArgs = append(sendArgs, sourceSnapshot)
+
 	if baseSnapshot != "" {
 		sendArgs = append(sendArgs, `-i`, baseSnapshot)
 	}
 
-	var (
-		recv = exec.Exec(
-			`zfs`, `recv`, `-u`,
-			fmt.Sprintf(
-				"%s/%s",
-				targetDataset,
-				sourceSnapshot,
-			),
-		)
+	return sendArgs
+}
 
-		send = exec.Exec(`zfs`, sendArgs...).NoStdLog()
+// ResumeCopyDataset continues interrupted receive into target dataset using
+// resume token obtained from target dataset. Total size is size of the rest
+// of the stream estimated by zfs send -nvP -t, it's used to report progress.
+func ResumeCopyDataset(
+	source Backend,
+	target Backend,
+	resumeToken string,
+	targetDataset string,
+	totalSize uint64,
+	progressFunc func(CopyProgress),
+	opts ...CopyOpts,
+) error {
+	return copyDataset(
+		source,
+		target,
+		[]string{`-t`, resumeToken},
+		targetDataset,
+		totalSize,
+		progressFunc,
+		opts...,
+	)
+}
+
+func copyDataset(
+	source Backend,
+	target Backend,
+	sendArgs []string,
+	targetDataset string,
+	totalSize uint64,
+	progressFunc func(CopyProgress),
+	opts ...CopyOpts,
+) error {
+	var (
+		force       bool
+		rateLimit   RateLimit
+		rateLimiter *RateLimiter
+		bufferSize  uint64
+		compression = CompressionNone
+		recvFlags   []string
+		recursive   bool
 	)
 
-	stdout, err := send.StdoutPipe()
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case CopyOptForce:
+			force = bool(opt)
+		case CopyOptRateLimit:
+			rateLimit = RateLimit(opt)
+		case CopyOptRateLimiter:
+			rateLimiter = (*RateLimiter)(opt)
+		case CopyOptBufferSize:
+			bufferSize = uint64(opt)
+		case CopyOptCompression:
+			compression = Compression(opt)
+		case CopyOptRecvFlags:
+			recvFlags = opt
+		case CopyOptRecursive:
+			recursive = bool(opt)
+		}
+	}
+
+	// there is no stream to buffer or compress in dry run mode
+	if exec.IsDryRun() {
+		bufferSize = 0
+		compression = CompressionNone
+	}
+
+	if target.IsLocal() {
+		compression = CompressionNone
+	}
+
+	var recvArgs []string
+
+	// -s makes receive resumable if it's interrupted
+	if !recursive {
+		recvArgs = append(recvArgs, `-s`)
+	}
+
+	recvArgs = append(recvArgs, `-u`)
+
+	if force {
+		recvArgs = append(recvArgs, `-F`)
+	}
+
+	recvArgs = append(recvArgs, recvFlags...)
+	recvArgs = append(recvArgs, targetDataset)
+
+	var counter sendProgressCounter
+
+	// -v makes zfs send to report progress once a second, -P makes the
+	// report parsable; amount of sent data is taken from the report
+	stream, err := source.Send(
+		counter.report,
+		append([]string{`-v`, `-P`}, sendArgs...)...,
+	)
 	if err != nil {
 		return err
 	}
 
 	progress := CopyProgress{
-		StartedAt: time.Now(),
+		StartedAt:  time.Now(),
+		TotalSize:  totalSize,
+		BufferSize: bufferSize,
 	}
 
-	sizeWritten, sizeReferenced, err := getSize(sourceSnapshot)
-	if err != nil {
-		return err
+	var reader io.Reader = stream
+
+	if !rateLimit.IsUnlimited() || !rateLimiter.IsUnlimited() {
+		reader = &rateLimitedReader{
+			reader:  stream,
+			limit:   rateLimit,
+			limiter: rateLimiter,
+		}
 	}
 
-	if baseSnapshot == "" {
-		progress.TotalSize = sizeReferenced
-	} else {
-		progress.TotalSize = sizeWritten
+	// stream is read in background by compression and buffer, they are
+	// stopped in reverse order before stream is closed, since zfs send
+	// can't be waited while its output is read
+	var stopReading []func()
+
+	closeStream := func() error {
+		for i := len(stopReading) - 1; i >= 0; i-- {
+			stopReading[i]()
+		}
+
+		return stream.Close()
+	}
+
+	if compression != CompressionNone {
+		log.Infof("{copy} compressing stream using %s", compression)
+
+		compressed := compress(reader, compression)
+
+		stopReading = append(stopReading, func() {
+			compressed.Close()
+		})
+
+		reader = compressed
+	}
+
+	var buffer *ringBuffer
+
+	if bufferSize > 0 {
+		buffer = newRingBuffer(bufferSize)
+
+		buffered := make(chan struct{})
+
+		go func(reader io.Reader) {
+			defer close(buffered)
+
+			_, err := io.Copy(buffer, reader)
+			buffer.CloseWithError(err)
+		}(reader)
+
+		stopReading = append(stopReading, func() {
+			buffer.Close()
+			<-buffered
+		})
+
+		reader = buffer
 	}
 
 	var (
-		progressWriter = ProgressWriter{}
-		progressDone   = make(chan struct{}, 1)
+		progressDone    = make(chan struct{})
+		progressStopped = make(chan struct{})
+		progressOnce    sync.Once
 	)
 
+	// progress is owned by the goroutine until it's stopped
 	go func() {
+		defer close(progressStopped)
+
 		for {
-			progress.SentSize = progressWriter.Total
+			progress.RateLimit = getEffectiveRate(
+				rateLimit,
+				rateLimiter,
+				time.Now(),
+			)
+
+			progress.setReported(&counter)
+
+			if buffer != nil {
+				progress.BufferUsed = buffer.Len()
+			}
+
 			progressFunc(progress)
 
 			select {
 			case <-progressDone:
 				return
-			default:
-				time.Sleep(time.Second)
+			case <-time.After(time.Second):
 			}
 		}
 	}()
 
-	defer func() {
-		progressDone <- struct{}{}
-	}()
-
-	recv.SetStdin(io.TeeReader(stdout, &progressWriter))
-
-	err = recv.Start()
-	if err != nil {
-		return karma.Format(
-			err,
-			"unable to start zfs recv",
-		)
-	}
-
-	err = send.Start()
-	if err != nil {
-		return karma.Format(
-			err,
-			"unable to run zfs send",
-		)
+	stopProgress := func() {
+		progressOnce.Do(func() {
+			close(progressDone)
+			<-progressStopped
+		})
 	}
 
-	progress.Sent = true
-
-	progressFunc(progress)
+	defer stopProgress()
 
-	log.Info("{copy} send thread finished, now waiting for receive")
-
-	err = recv.Wait()
+	if compression != CompressionNone {
+		err = target.ReceiveCompressed(reader, compression, recvArgs...)
+	} else {
+		err = target.Receive(reader, recvArgs...)
+	}
 	if err != nil {
-		send.Process().Kill()
+		closeStream()
 
-		return karma.Format(
-			err,
-			"unable to wait for zfs recv",
-		)
+		return err
 	}
 
-	err = send.Wait()
+	log.Info("{copy} receive finished, now waiting for send")
+
+	err = closeStream()
 	if err != nil {
 		return karma.Format(
 			err,
@@ -132,70 +407,31 @@ func CopyDataset(
 		)
 	}
 
-	return nil
-}
+	stopProgress()
 
-func getSize(snapshot string) (uint64, uint64, error) {
-	mappings, err := GetDatasetProperties([]PropertyRequest{
-		{Name: constants.Written, System: true, Snapshot: true},
-		{Name: constants.Referenced, System: true, Snapshot: true},
-	}, snapshot)
-	if err != nil {
-		return 0, 0, karma.Format(
-			err,
-			"unable to get used & referenced sizes for %q",
-			snapshot,
-		)
-	}
-
-	var (
-		sizeWritten    uint64
-		sizeReferenced uint64
-	)
+	progress.BufferUsed = 0
+	progress.Sent = true
+	progress.setReported(&counter)
 
-	if len(mappings) == 0 {
-		return 0, 0, karma.
-			Describe("snapshot", snapshot).
-			Reason(
-				"given dataset %q is not found",
-			)
-	}
+	progressFunc(progress)
 
-	for _, mapping := range mappings {
-		if mapping.Source != snapshot {
-			continue
-		}
+	return nil
+}
 
-		for _, property := range mapping.Properties {
-			switch property.Name {
-			case constants.Written:
-				size, err := strconv.ParseUint(property.Value, 10, 64)
-				if err != nil {
-					return 0, 0, karma.
-						Describe("size", property.Value).
-						Format(
-							err,
-							"unable to parse written size",
-						)
-				}
-
-				sizeWritten = size
-
-			case constants.Referenced:
-				size, err := strconv.ParseUint(property.Value, 10, 64)
-				if err != nil {
-					return 0, 0, karma.
-						Describe("size", property.Value).
-						Format(
-							err,
-							"unable to parse referenced size",
-						)
-				}
-
-				sizeReferenced = size
-			}
+// getEffectiveRate returns the lowest of rate limit of the stream and total
+// rate limit, zero if rate is not limited at all.
+func getEffectiveRate(
+	limit RateLimit,
+	limiter *RateLimiter,
+	now time.Time,
+) uint64 {
+	var effective uint64
+
+	for _, rate := range []uint64{limit.GetRate(now), limiter.GetRate(now)} {
+		if rate != 0 && (effective == 0 || rate < effective) {
+			effective = rate
 		}
 	}
 
-	return sizeWritten, sizeReferenced, nil
+	return effective
 }
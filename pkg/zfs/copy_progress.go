@@ -1,11 +1,80 @@
 package zfs
 
-type ProgressWriter struct {
-	Total uint64
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SendProgress is a progress of zfs send as reported by zfs send -v -P
+// itself once a second.
+type SendProgress struct {
+	// Snapshot is a full name of the snapshot which is being sent, it
+	// changes while replication stream is sent.
+	Snapshot string
+
+	// Sent is amount of data of the snapshot sent so far.
+	Sent uint64
+}
+
+// sendProgressCounter accumulates progress reported by zfs send, since
+// every snapshot of replication stream is reported separately.
+type sendProgressCounter struct {
+	mutex     sync.Mutex
+	completed uint64
+	current   SendProgress
+}
+
+func (counter *sendProgressCounter) report(progress SendProgress) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	if progress.Snapshot != counter.current.Snapshot {
+		counter.completed += counter.current.Sent
+	}
+
+	counter.current = progress
 }
 
-func (writer *ProgressWriter) Write(data []byte) (int, error) {
-	writer.Total += uint64(len(data))
+// get returns progress of the snapshot which is being sent and amount of
+// data sent so far.
+func (counter *sendProgressCounter) get() (SendProgress, uint64) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	return counter.current, counter.completed + counter.current.Sent
+}
+
+func (progress *CopyProgress) setReported(counter *sendProgressCounter) {
+	reported, sent := counter.get()
+
+	progress.SentSize = sent
+	progress.Snapshot = reported.Snapshot
+	progress.SnapshotSent = reported.Sent
+}
+
+// parseSendProgress parses progress line written by zfs send -v -P into
+// stderr, which looks like '<hh:mm:ss>\t<bytes>\t<snapshot>'. Other lines,
+// like stream size estimate or errors, are not parsed.
+func parseSendProgress(line string) (SendProgress, bool) {
+	fields := strings.Split(strings.TrimSpace(line), "\t")
+	if len(fields) != 3 {
+		return SendProgress{}, false
+	}
+
+	_, err := time.Parse("15:04:05", fields[0])
+	if err != nil {
+		return SendProgress{}, false
+	}
+
+	sent, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return SendProgress{}, false
+	}
 
-	return len(data), nil
+	return SendProgress{
+		Snapshot: fields[2],
+		Sent:     sent,
+	}, true
 }
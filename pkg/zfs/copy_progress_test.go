@@ -0,0 +1,67 @@
+package zfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSendProgress(t *testing.T) {
+	testcases := []struct {
+		line     string
+		expected SendProgress
+		ok       bool
+	}{
+		{
+			line: "12:00:01\t1048576\tzroot/home@zeus:2026-03-18T12:00:00Z\n",
+			expected: SendProgress{
+				Snapshot: "zroot/home@zeus:2026-03-18T12:00:00Z",
+				Sent:     1048576,
+			},
+			ok: true,
+		},
+		{line: "full\tzroot/home@a\t1048576"},
+		{line: "size\t1048576"},
+		{line: "12:00:01\tmany\tzroot/home@a"},
+		{line: "cannot send 'zroot/home@a': dataset does not exist"},
+		{line: ""},
+	}
+
+	for _, testcase := range testcases {
+		progress, ok := parseSendProgress(testcase.line)
+		if ok != testcase.ok || progress != testcase.expected {
+			t.Errorf(
+				"%q: expected %v (%v), got %v (%v)",
+				testcase.line,
+				testcase.expected,
+				testcase.ok,
+				progress,
+				ok,
+			)
+		}
+	}
+}
+
+func TestSendProgressCounter(t *testing.T) {
+	var counter sendProgressCounter
+
+	// replication stream reports every snapshot separately
+	for _, report := range []SendProgress{
+		{Snapshot: "zroot/home@a", Sent: 100},
+		{Snapshot: "zroot/home@a", Sent: 300},
+		{Snapshot: "zroot/home/a@a", Sent: 50},
+		{Snapshot: "zroot/home/b@a", Sent: 20},
+	} {
+		counter.report(report)
+	}
+
+	reported, sent := counter.get()
+
+	if sent != 370 {
+		t.Errorf("expected 370 bytes sent, got %d", sent)
+	}
+
+	expected := SendProgress{Snapshot: "zroot/home/b@a", Sent: 20}
+	if !reflect.DeepEqual(reported, expected) {
+		t.Errorf("expected %v reported, got %v", expected, reported)
+	}
+}
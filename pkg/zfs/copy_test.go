@@ -0,0 +1,143 @@
+package zfs
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSendStream is an endless stream of zfs send which records whether it
+// has been read after or while it has been closed.
+type testSendStream struct {
+	mutex      sync.Mutex
+	reading    int
+	closed     bool
+	readClosed bool
+}
+
+func (stream *testSendStream) Read(data []byte) (int, error) {
+	stream.mutex.Lock()
+
+	if stream.closed {
+		stream.readClosed = true
+		stream.mutex.Unlock()
+
+		return 0, io.ErrClosedPipe
+	}
+
+	stream.reading++
+	stream.mutex.Unlock()
+
+	// zfs send doesn't produce data instantly
+	time.Sleep(time.Millisecond)
+
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	stream.mutex.Lock()
+	stream.reading--
+	stream.mutex.Unlock()
+
+	return len(data), nil
+}
+
+func (stream *testSendStream) Close() error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if stream.reading > 0 {
+		stream.readClosed = true
+	}
+
+	stream.closed = true
+
+	return nil
+}
+
+// testCopyBackend sends endless stream and fails receive after reading some
+// of it.
+type testCopyBackend struct {
+	Backend
+
+	stream *testSendStream
+}
+
+func (backend *testCopyBackend) IsLocal() bool {
+	return false
+}
+
+func (backend *testCopyBackend) Send(
+	progress func(SendProgress),
+	args ...string,
+) (io.ReadCloser, error) {
+	return backend.stream, nil
+}
+
+func (backend *testCopyBackend) Receive(
+	stream io.Reader,
+	args ...string,
+) error {
+	_, err := io.CopyN(io.Discard, stream, 64*1024)
+	if err != nil {
+		return err
+	}
+
+	return errors.New("cannot receive: out of space")
+}
+
+func (backend *testCopyBackend) ReceiveCompressed(
+	stream io.Reader,
+	compression Compression,
+	args ...string,
+) error {
+	decompressed, err := Decompress(stream, compression)
+	if err != nil {
+		return err
+	}
+
+	defer decompressed.Close()
+
+	return backend.Receive(decompressed, args...)
+}
+
+func TestCopyDataset_StopsReadingBeforeClosingStream(t *testing.T) {
+	for _, testcase := range []struct {
+		name string
+		opts []CopyOpts
+	}{
+		{"plain", nil},
+		{"buffer", []CopyOpts{CopyOptBufferSize(4096)}},
+		{"compression", []CopyOpts{CopyOptCompression(CompressionZstd)}},
+		{
+			"buffer and compression",
+			[]CopyOpts{
+				CopyOptBufferSize(4096),
+				CopyOptCompression(CompressionLZ4),
+			},
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			backend := &testCopyBackend{stream: &testSendStream{}}
+
+			err := copyDataset(
+				backend,
+				backend,
+				[]string{`z/home@a`},
+				`zbackup/z/home`,
+				0,
+				func(CopyProgress) {},
+				testcase.opts...,
+			)
+			if err == nil {
+				t.Fatal("expected copy to fail")
+			}
+
+			if backend.stream.readClosed {
+				t.Error("stream has been read after it has been closed")
+			}
+		})
+	}
+}
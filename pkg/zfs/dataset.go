@@ -1,12 +1,13 @@
 package zfs
 
 import (
+	"strings"
+
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/zeus/pkg/exec"
 )
 
-func EnsureDatasetExists(dataset string) error {
-	err := exec.Exec(
+func (host *Host) EnsureDatasetExists(dataset string) error {
+	err := host.transport.Exec(
 		`zfs`, `create`,
 		`-p`, dataset, // `-o`, `encryption=on`
 	).Run()
@@ -21,3 +22,23 @@ func EnsureDatasetExists(dataset string) error {
 
 	return nil
 }
+
+func (host *Host) IsDatasetExists(dataset string) (bool, error) {
+	_, stderr, err := host.transport.Exec(
+		`zfs`, `list`, `-H`, `-o`, `name`, dataset,
+	).Output()
+	if err != nil {
+		if strings.Contains(stderr, "dataset does not exist") {
+			return false, nil
+		}
+
+		return false, karma.
+			Describe("dataset", dataset).
+			Format(
+				err,
+				"unable to check that dataset exists",
+			)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,78 @@
+package zfs
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+type SendEstimate struct {
+	// Snapshot is a full name of the snapshot which will be sent.
+	Snapshot string
+
+	// Base is a full name of the incremental base snapshot or empty string
+	// for full send.
+	Base string
+
+	Size uint64
+}
+
+// EstimateSend runs zfs send in dry-run mode with given arguments and parses
+// stream size from its parsable output.
+func (host *Host) EstimateSend(args ...string) (SendEstimate, error) {
+	stdout, stderr, err := host.transport.Exec(
+		`zfs`, append([]string{`send`, `-n`, `-v`, `-P`}, args...)...,
+	).Output()
+	if err != nil {
+		return SendEstimate{}, karma.
+			Describe("args", args).
+			Format(
+				err,
+				"unable to run zfs send in dry-run mode",
+			)
+	}
+
+	var (
+		estimate SendEstimate
+		found    bool
+	)
+
+	// depending on zfs version, parsable output is written either into
+	// stdout or into stderr
+	for _, line := range strings.Split(stdout+"\n"+stderr, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+
+		switch {
+		case fields[0] == "full" && len(fields) >= 3:
+			estimate.Snapshot = fields[1]
+
+		case fields[0] == "incremental" && len(fields) >= 4:
+			estimate.Base = fields[1]
+			estimate.Snapshot = fields[2]
+
+		case fields[0] == "size" && len(fields) == 2:
+			estimate.Size, err = strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return SendEstimate{}, karma.
+					Describe("line", line).
+					Format(
+						err,
+						"unable to parse estimated stream size",
+					)
+			}
+
+			found = true
+		}
+	}
+
+	if !found {
+		return SendEstimate{}, karma.
+			Describe("args", args).
+			Reason(
+				"zfs send dry-run output doesn't contain estimated stream size",
+			)
+	}
+
+	return estimate, nil
+}
@@ -0,0 +1,835 @@
+// Package fake provides in-memory implementation of zfs backend which can be
+// used to run backups without real pools.
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+const (
+	// PoolSize is a default size of every pool in bytes, used to report pool
+	// capacity and free space.
+	PoolSize = 1 << 40
+)
+
+// Backend models pools, datasets, snapshots, their GUIDs, holds, user
+// properties and encryption keys in memory.
+type Backend struct {
+	// Remote makes backend to report that pools are attached to another
+	// machine.
+	Remote bool
+
+	// Now returns time which is used as creation time of new snapshots.
+	Now func() time.Time
+
+	// InterruptReceive makes the next resumable receive to be interrupted,
+	// so target dataset is left with resume token.
+	InterruptReceive bool
+
+	mutex    sync.Mutex
+	pools    map[string]*pool
+	datasets map[string]*dataset
+	counter  uint64
+}
+
+type pool struct {
+	imported bool
+	detached bool
+	size     uint64
+}
+
+type dataset struct {
+	name       string
+	guid       string
+	createtxg  uint64
+	creation   time.Time
+	properties map[string]string
+	holds      map[string]bool
+
+	// referenced is amount of data in the dataset and written is amount of
+	// data written since the previous snapshot.
+	referenced uint64
+	written    uint64
+
+	// key is set only for encryption roots.
+	key       string
+	keyLoaded bool
+
+	// volsize is set only for volumes and their snapshots.
+	volsize uint64
+
+	// resumeToken is set if receive into the dataset has been interrupted.
+	resumeToken string
+}
+
+var (
+	_ zfs.Backend = (*Backend)(nil)
+)
+
+func New() *Backend {
+	return &Backend{
+		Now:      time.Now,
+		pools:    map[string]*pool{},
+		datasets: map[string]*dataset{},
+	}
+}
+
+// AddPool creates pool with given name and its root dataset.
+func (backend *Backend) AddPool(name string, imported bool) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	backend.pools[name] = &pool{imported: imported, size: PoolSize}
+	backend.datasets[name] = backend.newDataset(name)
+}
+
+// DetachPool simulates unplugging disk of given exported pool, so pool is not
+// available for import until it's attached back, while its datasets are
+// kept.
+func (backend *Backend) DetachPool(name string) error {
+	return backend.setPoolDetached(name, true)
+}
+
+// AttachPool attaches pool which has been detached by DetachPool.
+func (backend *Backend) AttachPool(name string) error {
+	return backend.setPoolDetached(name, false)
+}
+
+func (backend *Backend) setPoolDetached(name string, detached bool) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	pool, ok := backend.pools[name]
+	if !ok || pool.imported {
+		return fmt.Errorf("pool '%s' is imported or doesn't exist", name)
+	}
+
+	pool.detached = detached
+
+	return nil
+}
+
+// SetPoolSize changes size of given pool, so it can run out of space.
+func (backend *Backend) SetPoolSize(name string, size uint64) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	pool, ok := backend.pools[name]
+	if !ok {
+		return fmt.Errorf("cannot open '%s': no such pool", name)
+	}
+
+	pool.size = size
+
+	return nil
+}
+
+// getPoolUsed returns amount of data in datasets of given pool along with
+// data which is kept only by their snapshots. Snapshots are considered to
+// keep data written since the previous snapshot as if it has been
+// overwritten since then.
+func (backend *Backend) getPoolUsed(name string) uint64 {
+	var used uint64
+
+	for _, dataset := range backend.datasets {
+		if getPool(dataset.name) != name {
+			continue
+		}
+
+		if isSnapshot(dataset.name) {
+			used += dataset.written
+		} else {
+			used += dataset.referenced
+		}
+	}
+
+	return used
+}
+
+// getPoolAvailable returns amount of space left in given pool.
+func (backend *Backend) getPoolAvailable(name string) uint64 {
+	used := backend.getPoolUsed(name)
+
+	if used > backend.pools[name].size {
+		return 0
+	}
+
+	return backend.pools[name].size - used
+}
+
+// Write simulates writing given amount of data into the filesystem.
+func (backend *Backend) Write(name string, size uint64) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	dataset, err := backend.get(name)
+	if err != nil {
+		return err
+	}
+
+	dataset.referenced += size
+	dataset.written += size
+
+	return nil
+}
+
+// Encrypt makes given filesystem encryption root with given key. Key is
+// not loaded after this call.
+func (backend *Backend) Encrypt(name string, key string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	dataset, err := backend.get(name)
+	if err != nil {
+		return err
+	}
+
+	dataset.key = key
+	dataset.keyLoaded = false
+
+	return nil
+}
+
+// CreateVolume creates volume with given name and size, its parents should
+// exist.
+func (backend *Backend) CreateVolume(name string, size uint64) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	if _, ok := backend.datasets[name]; ok {
+		return fmt.Errorf("cannot create '%s': dataset already exists", name)
+	}
+
+	if _, err := backend.get(getParent(name)); err != nil {
+		return fmt.Errorf("cannot create '%s': parent does not exist", name)
+	}
+
+	volume := backend.newDataset(name)
+	volume.volsize = size
+
+	backend.datasets[name] = volume
+
+	return nil
+}
+
+// RenameSnapshot renames given snapshot within its filesystem, snapshot
+// keeps its GUID.
+func (backend *Backend) RenameSnapshot(name string, newName string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	snapshot, err := backend.getSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	if getParent(newName) != getParent(name) {
+		return fmt.Errorf(
+			"cannot rename to '%s': snapshots must be part of same dataset",
+			newName,
+		)
+	}
+
+	if _, ok := backend.datasets[newName]; ok {
+		return fmt.Errorf(
+			"cannot rename to '%s': dataset already exists",
+			newName,
+		)
+	}
+
+	delete(backend.datasets, name)
+
+	snapshot.name = newName
+	backend.datasets[newName] = snapshot
+
+	return nil
+}
+
+func (backend *Backend) IsLocal() bool {
+	return !backend.Remote
+}
+
+func (backend *Backend) String() string {
+	if backend.Remote {
+		return "fake remote"
+	}
+
+	return "fake"
+}
+
+func (backend *Backend) GetImportList() ([]string, error) {
+	return backend.listPools(false), nil
+}
+
+func (backend *Backend) GetImportedPools() ([]string, error) {
+	return backend.listPools(true), nil
+}
+
+func (backend *Backend) ImportPool(name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	pool, ok := backend.pools[name]
+	if !ok || pool.detached {
+		return fmt.Errorf("cannot import '%s': no such pool available", name)
+	}
+
+	if pool.imported {
+		return fmt.Errorf(
+			"cannot import '%s': a pool with that name already exists",
+			name,
+		)
+	}
+
+	pool.imported = true
+
+	return nil
+}
+
+func (backend *Backend) ExportPool(name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	pool, ok := backend.pools[name]
+	if !ok || !pool.imported {
+		return fmt.Errorf("cannot open '%s': no such pool", name)
+	}
+
+	pool.imported = false
+
+	return nil
+}
+
+func (backend *Backend) GetPoolProperties(
+	requests []zfs.PropertyRequest,
+	pools ...string,
+) ([]zfs.PropertyMapping, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	if len(pools) == 0 {
+		pools = backend.listPoolsLocked(true)
+	}
+
+	mappings := []zfs.PropertyMapping{}
+
+	for _, name := range pools {
+		pool, ok := backend.pools[name]
+		if !ok || !pool.imported {
+			return nil, fmt.Errorf("cannot open '%s': no such pool", name)
+		}
+
+		used := backend.getPoolUsed(name)
+
+		values := map[string]string{
+			"guid":     backend.datasets[name].guid,
+			"health":   "ONLINE",
+			"size":     fmt.Sprint(pool.size),
+			"capacity": fmt.Sprint(used * 100 / pool.size),
+			"free":     fmt.Sprint(backend.getPoolAvailable(name)),
+		}
+
+		mapping := zfs.PropertyMapping{Source: name}
+
+		for _, request := range requests {
+			value, ok := values[request.Name]
+			if !ok {
+				continue
+			}
+
+			mapping.Properties = append(mapping.Properties, zfs.Property{
+				PropertyRequest: zfs.PropertyRequest{Name: request.Name},
+				Source:          name,
+				Value:           value,
+			})
+		}
+
+		if len(mapping.Properties) > 0 {
+			mappings = append(mappings, mapping)
+		}
+	}
+
+	return mappings, nil
+}
+
+func (backend *Backend) GetDatasetProperties(
+	requests []zfs.PropertyRequest,
+	datasets ...string,
+) ([]zfs.PropertyMapping, error) {
+	return backend.getProperties(false, requests, datasets...)
+}
+
+func (backend *Backend) GetDatasetPropertiesRecursive(
+	requests []zfs.PropertyRequest,
+	datasets ...string,
+) ([]zfs.PropertyMapping, error) {
+	return backend.getProperties(true, requests, datasets...)
+}
+
+func (backend *Backend) SetDatasetProperty(
+	name string,
+	property string,
+	value string,
+) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	dataset, err := backend.get(name)
+	if err != nil {
+		return err
+	}
+
+	if !isStoredProperty(property) {
+		return fmt.Errorf(
+			"cannot set property for '%s': "+
+				"only user properties are supported by fake backend",
+			name,
+		)
+	}
+
+	dataset.properties[property] = value
+
+	return nil
+}
+
+func (backend *Backend) EnsureDatasetExists(name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	if !backend.isPoolImported(getPool(name)) {
+		return fmt.Errorf("cannot create '%s': no such pool", name)
+	}
+
+	parts := strings.Split(name, "/")
+
+	for i := range parts {
+		path := strings.Join(parts[:i+1], "/")
+
+		if _, ok := backend.datasets[path]; !ok {
+			backend.datasets[path] = backend.newDataset(path)
+		}
+	}
+
+	return nil
+}
+
+func (backend *Backend) IsDatasetExists(name string) (bool, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	_, err := backend.get(name)
+
+	return err == nil, nil
+}
+
+func (backend *Backend) DestroyDataset(name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	dataset, err := backend.get(name)
+	if err != nil {
+		return err
+	}
+
+	if len(dataset.holds) > 0 {
+		return fmt.Errorf("cannot destroy '%s': dataset is busy", name)
+	}
+
+	if len(backend.getChildren(name)) > 0 {
+		return fmt.Errorf(
+			"cannot destroy '%s': filesystem has children",
+			name,
+		)
+	}
+
+	delete(backend.datasets, name)
+
+	return nil
+}
+
+func (backend *Backend) CreateSnapshot(name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	filesystem, _, err := zfs.SplitSnapshotName(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := backend.get(filesystem); err != nil {
+		return err
+	}
+
+	return backend.createSnapshots([]string{name})
+}
+
+func (backend *Backend) CreateSnapshotRecursive(name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	filesystem, snapshot, err := zfs.SplitSnapshotName(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := backend.get(filesystem); err != nil {
+		return err
+	}
+
+	names := []string{}
+
+	for _, dataset := range backend.walk(filesystem, true) {
+		if !isSnapshot(dataset.name) {
+			names = append(names, dataset.name+"@"+snapshot)
+		}
+	}
+
+	return backend.createSnapshots(names)
+}
+
+// createSnapshots creates all given snapshots or none of them if any
+// already exists.
+func (backend *Backend) createSnapshots(names []string) error {
+	for _, name := range names {
+		if _, ok := backend.datasets[name]; ok {
+			return fmt.Errorf(
+				"cannot create snapshot '%s': dataset already exists",
+				name,
+			)
+		}
+	}
+
+	for _, name := range names {
+		parent := backend.datasets[getParent(name)]
+
+		snapshot := backend.newDataset(name)
+		snapshot.referenced = parent.referenced
+		snapshot.written = parent.written
+		snapshot.volsize = parent.volsize
+
+		parent.written = 0
+
+		backend.datasets[name] = snapshot
+	}
+
+	return nil
+}
+
+func (backend *Backend) ListSnapshots(name string) ([]string, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	if _, err := backend.get(name); err != nil {
+		return nil, err
+	}
+
+	snapshots := []string{}
+
+	for _, dataset := range backend.walk(name, true) {
+		if isSnapshot(dataset.name) {
+			snapshots = append(snapshots, dataset.name)
+		}
+	}
+
+	return snapshots, nil
+}
+
+func (backend *Backend) Hold(tag string, name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	snapshot, err := backend.getSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.holds[tag] {
+		return fmt.Errorf(
+			"cannot hold snapshot '%s': tag already exists on this dataset",
+			name,
+		)
+	}
+
+	snapshot.holds[tag] = true
+
+	return nil
+}
+
+func (backend *Backend) Release(tag string, name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	snapshot, err := backend.getSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	if !snapshot.holds[tag] {
+		return fmt.Errorf(
+			"cannot release hold from snapshot '%s': no such tag on this dataset",
+			name,
+		)
+	}
+
+	delete(snapshot.holds, tag)
+
+	return nil
+}
+
+func (backend *Backend) ListHolds(name string) ([]string, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	snapshot, err := backend.getSnapshot(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snapshot.holds) == 0 {
+		return nil, nil
+	}
+
+	tags := []string{}
+
+	for tag := range snapshot.holds {
+		tags = append(tags, tag)
+	}
+
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+func (backend *Backend) HasHold(tag string, name string) (bool, error) {
+	tags, err := backend.ListHolds(name)
+	if err != nil {
+		return false, err
+	}
+
+	for _, candidate := range tags {
+		if candidate == tag {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (backend *Backend) LoadKey(name string, key string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	dataset, err := backend.get(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case dataset.key == "":
+		return fmt.Errorf(
+			"Key load error: '%s' is not an encryption root.",
+			name,
+		)
+	case dataset.keyLoaded:
+		return fmt.Errorf("Key load error: Key already loaded for '%s'.", name)
+	case dataset.key != key:
+		return fmt.Errorf(
+			"Key load error: Incorrect key provided for '%s'.",
+			name,
+		)
+	}
+
+	dataset.keyLoaded = true
+
+	return nil
+}
+
+func (backend *Backend) UnloadKey(name string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	dataset, err := backend.get(name)
+	if err != nil {
+		return err
+	}
+
+	if dataset.key == "" || !dataset.keyLoaded {
+		return fmt.Errorf("Key unload error: '%s' key is not loaded.", name)
+	}
+
+	dataset.keyLoaded = false
+
+	return nil
+}
+
+func (backend *Backend) GetResumeToken(name string) (string, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	dataset, ok := backend.datasets[name]
+	if !ok {
+		return "", nil
+	}
+
+	return dataset.resumeToken, nil
+}
+
+func (backend *Backend) listPools(imported bool) []string {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	return backend.listPoolsLocked(imported)
+}
+
+func (backend *Backend) listPoolsLocked(imported bool) []string {
+	pools := []string{}
+
+	for name, pool := range backend.pools {
+		if pool.detached {
+			continue
+		}
+
+		if pool.imported == imported {
+			pools = append(pools, name)
+		}
+	}
+
+	sort.Strings(pools)
+
+	return pools
+}
+
+func (backend *Backend) isPoolImported(name string) bool {
+	pool, ok := backend.pools[name]
+
+	return ok && pool.imported
+}
+
+func (backend *Backend) newDataset(name string) *dataset {
+	backend.counter++
+
+	return &dataset{
+		name:       name,
+		guid:       fmt.Sprint(uint64(1<<60) + backend.counter),
+		createtxg:  backend.counter,
+		creation:   backend.Now(),
+		properties: map[string]string{},
+		holds:      map[string]bool{},
+	}
+}
+
+// get returns dataset or snapshot with given name if its pool is imported.
+func (backend *Backend) get(name string) (*dataset, error) {
+	dataset, ok := backend.datasets[name]
+	if !ok || !backend.isPoolImported(getPool(name)) {
+		return nil, fmt.Errorf("cannot open '%s': dataset does not exist", name)
+	}
+
+	return dataset, nil
+}
+
+func (backend *Backend) getSnapshot(name string) (*dataset, error) {
+	if !isSnapshot(name) {
+		return nil, fmt.Errorf("'%s' is not a snapshot", name)
+	}
+
+	return backend.get(name)
+}
+
+// getChildren returns direct child filesystems and snapshots of given
+// filesystem.
+func (backend *Backend) getChildren(name string) []*dataset {
+	children := []*dataset{}
+
+	for _, dataset := range backend.datasets {
+		if getParent(dataset.name) == name {
+			children = append(children, dataset)
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].createtxg < children[j].createtxg
+	})
+
+	return children
+}
+
+// walk returns given dataset and, if recursive, all its descendants in the
+// same order as zfs list does: snapshots of filesystem by creation order
+// follow the filesystem and precede its child filesystems.
+func (backend *Backend) walk(name string, recursive bool) []*dataset {
+	root, ok := backend.datasets[name]
+	if !ok {
+		return nil
+	}
+
+	result := []*dataset{root}
+
+	if !recursive || isSnapshot(name) {
+		return result
+	}
+
+	var filesystems []*dataset
+
+	for _, child := range backend.getChildren(name) {
+		if isSnapshot(child.name) {
+			result = append(result, child)
+		} else {
+			filesystems = append(filesystems, child)
+		}
+	}
+
+	sort.Slice(filesystems, func(i, j int) bool {
+		return filesystems[i].name < filesystems[j].name
+	})
+
+	for _, filesystem := range filesystems {
+		result = append(result, backend.walk(filesystem.name, true)...)
+	}
+
+	return result
+}
+
+// getEncryptionRoot returns encryption root of given dataset or nil if
+// dataset is not encrypted.
+func (backend *Backend) getEncryptionRoot(name string) *dataset {
+	for name != "" {
+		dataset, ok := backend.datasets[name]
+		if ok && dataset.key != "" {
+			return dataset
+		}
+
+		name = getParent(name)
+	}
+
+	return nil
+}
+
+func isSnapshot(name string) bool {
+	return strings.Contains(name, "@")
+}
+
+func getPool(name string) string {
+	return strings.SplitN(strings.SplitN(name, "@", 2)[0], "/", 2)[0]
+}
+
+// getParent returns filesystem which given snapshot belongs to or parent of
+// given filesystem.
+func getParent(name string) string {
+	if isSnapshot(name) {
+		return strings.SplitN(name, "@", 2)[0]
+	}
+
+	index := strings.LastIndex(name, "/")
+	if index < 0 {
+		return ""
+	}
+
+	return name[:index]
+}
@@ -0,0 +1,310 @@
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/reconquest/zeus/pkg/constants"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+const (
+	sourceLocal     = "local"
+	sourceInherited = "inherited"
+	sourceNone      = "none"
+)
+
+// inheritableProperties are system properties which are stored on datasets
+// and inherited by descendants the same way as user properties.
+var inheritableProperties = map[string]bool{
+	constants.Mountpoint: true,
+	constants.Volmode:    true,
+}
+
+func isStoredProperty(name string) bool {
+	return strings.Contains(name, ":") || inheritableProperties[name]
+}
+
+func (backend *Backend) getProperties(
+	recursive bool,
+	requests []zfs.PropertyRequest,
+	names ...string,
+) ([]zfs.PropertyMapping, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	var (
+		types   = map[string]bool{}
+		sources = map[string]bool{}
+	)
+
+	for _, request := range requests {
+		types["snapshot"] = types["snapshot"] || request.Snapshot
+		types["filesystem"] = types["filesystem"] || request.Filesystem
+		types["volume"] = types["volume"] || request.Volume
+
+		sources[sourceLocal] = sources[sourceLocal] || request.Local
+		sources[sourceInherited] = sources[sourceInherited] ||
+			request.Inherited
+		sources[sourceNone] = sources[sourceNone] || request.System
+	}
+
+	anyType := !types["snapshot"] && !types["filesystem"] && !types["volume"]
+	anySource := !sources[sourceLocal] &&
+		!sources[sourceInherited] &&
+		!sources[sourceNone]
+
+	var datasets []*dataset
+
+	if len(names) == 0 {
+		for _, pool := range backend.listPoolsLocked(true) {
+			datasets = append(datasets, backend.walk(pool, true)...)
+		}
+	}
+
+	for _, name := range names {
+		if _, err := backend.get(name); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case recursive:
+			datasets = append(datasets, backend.walk(name, true)...)
+
+		// zfs lists snapshots of given filesystem if only snapshots are
+		// requested
+		case types["snapshot"] && !types["filesystem"] && !types["volume"] &&
+			!isSnapshot(name):
+			for _, child := range backend.getChildren(name) {
+				if isSnapshot(child.name) {
+					datasets = append(datasets, child)
+				}
+			}
+
+		default:
+			datasets = append(datasets, backend.datasets[name])
+		}
+	}
+
+	mappings := []zfs.PropertyMapping{}
+
+	for _, dataset := range datasets {
+		if !anyType && !types[getType(dataset)] {
+			continue
+		}
+
+		mapping := zfs.PropertyMapping{Source: dataset.name}
+		seen := map[string]bool{}
+
+		for _, request := range expandAll(dataset, requests) {
+			if seen[request.Name] {
+				continue
+			}
+
+			seen[request.Name] = true
+
+			value, source, from := backend.getProperty(dataset, request.Name)
+			if value == "" {
+				continue
+			}
+
+			if !anySource && !sources[source] {
+				continue
+			}
+
+			if source == sourceInherited && !request.Inherited {
+				continue
+			}
+
+			property := zfs.Property{
+				PropertyRequest: zfs.PropertyRequest{
+					Name:      request.Name,
+					Inherited: source == sourceInherited,
+				},
+				Source: dataset.name,
+				Value:  value,
+			}
+
+			switch source {
+			case sourceLocal:
+				property.SourceKind = zfs.SourceLocal
+			case sourceInherited:
+				property.SourceKind = zfs.SourceInherited
+				property.InheritedFrom = from
+			}
+
+			mapping.Properties = append(mapping.Properties, property)
+		}
+
+		if len(mapping.Properties) > 0 {
+			mappings = append(mappings, mapping)
+		}
+	}
+
+	return mappings, nil
+}
+
+// expandAll replaces request of 'all' properties with requests of user
+// properties set on given dataset, system properties are not listed.
+func expandAll(
+	dataset *dataset,
+	requests []zfs.PropertyRequest,
+) []zfs.PropertyRequest {
+	expanded := []zfs.PropertyRequest{}
+
+	for _, request := range requests {
+		if request.Name != "all" {
+			expanded = append(expanded, request)
+
+			continue
+		}
+
+		names := []string{}
+		for name := range dataset.properties {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			request.Name = name
+
+			expanded = append(expanded, request)
+		}
+	}
+
+	return expanded
+}
+
+// getProperty returns value of given property along with its source and name
+// of the dataset it's inherited from or empty string if property is not set.
+func (backend *Backend) getProperty(
+	dataset *dataset,
+	name string,
+) (string, string, string) {
+	// written@snapshot is amount of data written since given snapshot
+	if strings.HasPrefix(name, constants.Written+"@") {
+		return backend.getWrittenSince(
+			dataset,
+			strings.TrimPrefix(name, constants.Written+"@"),
+		), sourceNone, ""
+	}
+
+	if isStoredProperty(name) {
+		for current := dataset; current != nil; {
+			if value, ok := current.properties[name]; ok {
+				if current == dataset {
+					return value, sourceLocal, ""
+				}
+
+				return value, sourceInherited, current.name
+			}
+
+			current = backend.datasets[getParent(current.name)]
+		}
+
+		return "", "", ""
+	}
+
+	root := backend.getEncryptionRoot(dataset.name)
+
+	var value string
+
+	switch name {
+	case constants.GUID:
+		value = dataset.guid
+
+	case "createtxg":
+		value = fmt.Sprint(dataset.createtxg)
+
+	case constants.Creation:
+		value = fmt.Sprint(dataset.creation.Unix())
+
+	case constants.Referenced:
+		value = fmt.Sprint(dataset.referenced)
+
+	case constants.Written:
+		value = fmt.Sprint(dataset.written)
+
+	case constants.Used:
+		if isSnapshot(dataset.name) {
+			value = fmt.Sprint(dataset.written)
+		} else {
+			value = fmt.Sprint(dataset.referenced)
+		}
+
+	case constants.Available:
+		if !isSnapshot(dataset.name) {
+			value = fmt.Sprint(
+				backend.getPoolAvailable(getPool(dataset.name)),
+			)
+		}
+
+	case constants.UserRefs:
+		if isSnapshot(dataset.name) {
+			value = fmt.Sprint(len(dataset.holds))
+		}
+
+	case constants.Type:
+		value = getType(dataset)
+
+	case constants.Volsize:
+		if dataset.volsize > 0 {
+			value = fmt.Sprint(dataset.volsize)
+		}
+
+	case constants.Keystatus:
+		if root != nil {
+			if root.keyLoaded {
+				value = constants.KeystatusAvailable
+			} else {
+				value = "unavailable"
+			}
+		}
+
+	case constants.EncryptionRoot:
+		if root != nil {
+			value = root.name
+		}
+	}
+
+	return value, sourceNone, ""
+}
+
+// getWrittenSince returns amount of data written into given filesystem
+// since given snapshot of it, empty if there is no such snapshot.
+func (backend *Backend) getWrittenSince(
+	dataset *dataset,
+	snapshot string,
+) string {
+	if isSnapshot(dataset.name) {
+		return ""
+	}
+
+	base, ok := backend.datasets[dataset.name+"@"+snapshot]
+	if !ok {
+		return ""
+	}
+
+	written := dataset.written
+
+	for _, child := range backend.getChildren(dataset.name) {
+		if isSnapshot(child.name) && child.createtxg > base.createtxg {
+			written += child.written
+		}
+	}
+
+	return fmt.Sprint(written)
+}
+
+func getType(dataset *dataset) string {
+	switch {
+	case isSnapshot(dataset.name):
+		return "snapshot"
+	case dataset.volsize > 0:
+		return constants.TypeVolume
+	default:
+		return "filesystem"
+	}
+}
@@ -0,0 +1,687 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/zfs"
+)
+
+// stream is a content of send stream produced by fake backend.
+type stream struct {
+	Snapshot   string    `json:"snapshot"`
+	GUID       string    `json:"guid"`
+	Creation   time.Time `json:"creation"`
+	Referenced uint64    `json:"referenced"`
+	Written    uint64    `json:"written"`
+
+	// Base and BaseGUID are set for incremental streams only.
+	Base     string `json:"base,omitempty"`
+	BaseGUID string `json:"base_guid,omitempty"`
+
+	// Key is set for raw streams of encrypted datasets, received dataset
+	// becomes encryption root with the same key.
+	Raw bool   `json:"raw,omitempty"`
+	Key string `json:"key,omitempty"`
+
+	// Volsize is set for streams of volumes.
+	Volsize uint64 `json:"volsize,omitempty"`
+
+	// Children are set for replication streams only, they are streams of
+	// descendants and Path is their name relative to the sent filesystem.
+	Path     string   `json:"path,omitempty"`
+	Children []stream `json:"children,omitempty"`
+
+	// Properties are set for replication streams only, they are properties
+	// set locally on sent datasets.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sendArgs struct {
+	snapshot  string
+	base      string
+	token     string
+	raw       bool
+	recursive bool
+}
+
+// Send returns stream of given snapshot, progress of every snapshot in the
+// stream is reported once as if it has been sent within a second.
+func (backend *Backend) Send(
+	progress func(zfs.SendProgress),
+	args ...string,
+) (io.ReadCloser, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	stream, err := backend.getStream(args)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if progress != nil {
+		stream.reportProgress(progress)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (stream stream) reportProgress(progress func(zfs.SendProgress)) {
+	sent := stream.Referenced
+
+	if stream.Base != "" {
+		sent = stream.Written
+	}
+
+	progress(zfs.SendProgress{
+		Snapshot: stream.Snapshot,
+		Sent:     sent,
+	})
+
+	for _, child := range stream.Children {
+		child.reportProgress(progress)
+	}
+}
+
+func (backend *Backend) EstimateSend(args ...string) (zfs.SendEstimate, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	stream, err := backend.getStream(args)
+	if err != nil {
+		return zfs.SendEstimate{}, err
+	}
+
+	return zfs.SendEstimate{
+		Snapshot: stream.Snapshot,
+		Base:     stream.Base,
+		Size:     stream.getSize(),
+	}, nil
+}
+
+// getSize returns amount of data in the stream including streams of
+// descendants.
+func (stream stream) getSize() uint64 {
+	size := stream.Referenced
+
+	if stream.Base != "" {
+		size = stream.Written
+	}
+
+	for _, child := range stream.Children {
+		size += child.getSize()
+	}
+
+	return size
+}
+
+func (backend *Backend) Receive(reader io.Reader, args ...string) error {
+	var stream stream
+
+	err := json.NewDecoder(reader).Decode(&stream)
+	if err != nil {
+		return karma.Format(
+			err,
+			"cannot receive: invalid stream",
+		)
+	}
+
+	var (
+		force     bool
+		resumable bool
+		target    string
+		exclude   = map[string]bool{}
+		set       = map[string]string{}
+	)
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == `-F`:
+			force = true
+		case arg == `-s`:
+			resumable = true
+		case arg == `-x` && i+1 < len(args):
+			i++
+			exclude[args[i]] = true
+		case arg == `-o` && i+1 < len(args):
+			i++
+			property := strings.SplitN(args[i], "=", 2)
+			if len(property) == 2 {
+				set[property[0]] = property[1]
+			}
+		case strings.HasPrefix(arg, `-`):
+			// other flags do not change received data
+		default:
+			target = arg
+		}
+	}
+
+	if target == "" {
+		return fmt.Errorf("cannot receive: missing target dataset")
+	}
+
+	_, name, err := zfs.SplitSnapshotName(stream.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	filesystem := target
+
+	if isSnapshot(target) {
+		filesystem, name, err = zfs.SplitSnapshotName(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	err = backend.prepareResume(filesystem, stream)
+	if err != nil {
+		return err
+	}
+
+	if resumable && backend.InterruptReceive {
+		backend.InterruptReceive = false
+
+		return backend.interruptReceive(filesystem, stream)
+	}
+
+	err = backend.receiveStream(filesystem, name, stream, force)
+	if err != nil {
+		return err
+	}
+
+	backend.receiveProperties(filesystem, stream.Properties, exclude)
+
+	// properties set on receive are inherited by descendants
+	for property, value := range set {
+		backend.datasets[filesystem].properties[property] = value
+	}
+
+	// descendants go after their parents in replication stream
+	for _, child := range stream.Children {
+		err = backend.receiveStream(filesystem+child.Path, name, child, force)
+		if err != nil {
+			return err
+		}
+
+		backend.receiveProperties(
+			filesystem+child.Path,
+			child.Properties,
+			exclude,
+		)
+	}
+
+	return nil
+}
+
+// receiveProperties sets properties from replication stream on received
+// filesystem except excluded ones.
+func (backend *Backend) receiveProperties(
+	filesystem string,
+	properties map[string]string,
+	exclude map[string]bool,
+) {
+	for property, value := range properties {
+		if !exclude[property] {
+			backend.datasets[filesystem].properties[property] = value
+		}
+	}
+}
+
+// resumeToken is a content of resume token made by fake backend, it
+// describes stream which receive has been interrupted.
+type resumeToken struct {
+	Snapshot string `json:"snapshot"`
+	Base     string `json:"base,omitempty"`
+	Raw      bool   `json:"raw,omitempty"`
+}
+
+// interruptReceive leaves target filesystem partially received with resume
+// token instead of receiving given stream.
+func (backend *Backend) interruptReceive(
+	filesystem string,
+	stream stream,
+) error {
+	if stream.Base == "" {
+		err := backend.receiveFull(filesystem, false)
+		if err != nil {
+			return err
+		}
+	} else if _, err := backend.get(filesystem); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(resumeToken{
+		Snapshot: stream.Snapshot,
+		Base:     stream.Base,
+		Raw:      stream.Raw,
+	})
+	if err != nil {
+		return err
+	}
+
+	backend.datasets[filesystem].resumeToken = base64.StdEncoding.
+		EncodeToString(data)
+
+	return fmt.Errorf("cannot receive: stream has been interrupted")
+}
+
+// prepareResume discards partially received data of the interrupted
+// receive, so stream can be received again from the start.
+func (backend *Backend) prepareResume(
+	filesystem string,
+	stream stream,
+) error {
+	dataset, ok := backend.datasets[filesystem]
+	if !ok || dataset.resumeToken == "" {
+		return nil
+	}
+
+	token, err := decodeResumeToken(dataset.resumeToken)
+	if err != nil {
+		return err
+	}
+
+	if token.Snapshot != stream.Snapshot {
+		return fmt.Errorf(
+			"cannot receive: destination '%s' contains partially-complete "+
+				"state from \"zfs receive -s\"",
+			filesystem,
+		)
+	}
+
+	dataset.resumeToken = ""
+
+	// partially received filesystem is created by interrupted full receive
+	if stream.Base == "" {
+		delete(backend.datasets, filesystem)
+	}
+
+	return nil
+}
+
+func decodeResumeToken(value string) (resumeToken, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return resumeToken{}, fmt.Errorf("invalid resume token: %s", err)
+	}
+
+	var token resumeToken
+
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return resumeToken{}, fmt.Errorf("invalid resume token: %s", err)
+	}
+
+	return token, nil
+}
+
+func (backend *Backend) receiveStream(
+	filesystem string,
+	name string,
+	stream stream,
+	force bool,
+) error {
+	var err error
+
+	if stream.Base == "" {
+		err = backend.receiveFull(filesystem, force)
+		if err == nil && stream.Key != "" {
+			backend.datasets[filesystem].key = stream.Key
+		}
+	} else {
+		err = backend.receiveIncremental(filesystem, stream, force)
+	}
+	if err != nil {
+		return err
+	}
+
+	parent := backend.datasets[filesystem]
+	parent.referenced = stream.Referenced
+	parent.written = 0
+	parent.volsize = stream.Volsize
+
+	snapshot := backend.newDataset(filesystem + "@" + name)
+	snapshot.guid = stream.GUID
+	snapshot.creation = stream.Creation
+	snapshot.referenced = stream.Referenced
+	snapshot.written = stream.Written
+	snapshot.volsize = stream.Volsize
+
+	backend.datasets[snapshot.name] = snapshot
+
+	return nil
+}
+
+func (backend *Backend) ReceiveCompressed(
+	reader io.Reader,
+	compression zfs.Compression,
+	args ...string,
+) error {
+	decompressed, err := zfs.Decompress(reader, compression)
+	if err != nil {
+		return err
+	}
+
+	defer decompressed.Close()
+
+	return backend.Receive(decompressed, args...)
+}
+
+func (backend *Backend) receiveFull(filesystem string, force bool) error {
+	if _, err := backend.get(filesystem); err == nil {
+		if !force {
+			return fmt.Errorf(
+				"cannot receive new filesystem stream: "+
+					"destination '%s' exists, must specify -F to overwrite it",
+				filesystem,
+			)
+		}
+
+		for _, dataset := range backend.walk(filesystem, true) {
+			if len(dataset.holds) > 0 {
+				return fmt.Errorf(
+					"cannot receive new filesystem stream: "+
+						"snapshot '%s' is held",
+					dataset.name,
+				)
+			}
+		}
+
+		for _, dataset := range backend.walk(filesystem, true) {
+			delete(backend.datasets, dataset.name)
+		}
+	}
+
+	if _, err := backend.get(getParent(filesystem)); err != nil {
+		return fmt.Errorf(
+			"cannot receive new filesystem stream: "+
+				"parent of '%s' does not exist",
+			filesystem,
+		)
+	}
+
+	backend.datasets[filesystem] = backend.newDataset(filesystem)
+
+	return nil
+}
+
+func (backend *Backend) receiveIncremental(
+	filesystem string,
+	stream stream,
+	force bool,
+) error {
+	if _, err := backend.get(filesystem); err != nil {
+		return fmt.Errorf(
+			"cannot receive incremental stream: destination '%s' does not exist",
+			filesystem,
+		)
+	}
+
+	var (
+		snapshots []*dataset
+		base      = -1
+	)
+
+	for _, child := range backend.getChildren(filesystem) {
+		if !isSnapshot(child.name) {
+			continue
+		}
+
+		if child.guid == stream.GUID {
+			return fmt.Errorf(
+				"cannot receive incremental stream: "+
+					"destination already contains snapshot %q",
+				child.name,
+			)
+		}
+
+		if child.guid == stream.BaseGUID {
+			base = len(snapshots)
+		}
+
+		snapshots = append(snapshots, child)
+	}
+
+	if base < 0 {
+		return fmt.Errorf(
+			"cannot receive incremental stream: "+
+				"destination '%s' does not contain incremental source",
+			filesystem,
+		)
+	}
+
+	newer := snapshots[base+1:]
+
+	if len(newer) > 0 && !force {
+		return fmt.Errorf(
+			"cannot receive incremental stream: "+
+				"most recent snapshot of '%s' does not match incremental source",
+			filesystem,
+		)
+	}
+
+	// -F rolls destination back to the incremental source
+	for _, snapshot := range newer {
+		if len(snapshot.holds) > 0 {
+			return fmt.Errorf(
+				"cannot receive incremental stream: snapshot '%s' is held",
+				snapshot.name,
+			)
+		}
+
+		delete(backend.datasets, snapshot.name)
+	}
+
+	return nil
+}
+
+// getLocalProperties returns copy of properties set locally on given dataset.
+func (backend *Backend) getLocalProperties(name string) map[string]string {
+	properties := map[string]string{}
+
+	for property, value := range backend.datasets[name].properties {
+		properties[property] = value
+	}
+
+	return properties
+}
+
+func (backend *Backend) getStream(args []string) (stream, error) {
+	parsed, err := parseSendArgs(args)
+	if err != nil {
+		return stream{}, err
+	}
+
+	if parsed.token != "" {
+		token, err := decodeResumeToken(parsed.token)
+		if err != nil {
+			return stream{}, err
+		}
+
+		return backend.getSnapshotStream(token.Snapshot, token.Base, token.Raw)
+	}
+
+	result, err := backend.getSnapshotStream(
+		parsed.snapshot,
+		parsed.base,
+		parsed.raw,
+	)
+	if err != nil {
+		return stream{}, err
+	}
+
+	if !parsed.recursive {
+		return result, nil
+	}
+
+	filesystem, name, err := zfs.SplitSnapshotName(result.Snapshot)
+	if err != nil {
+		return stream{}, err
+	}
+
+	result.Properties = backend.getLocalProperties(filesystem)
+
+	for _, dataset := range backend.walk(filesystem, true) {
+		if isSnapshot(dataset.name) || dataset.name == filesystem {
+			continue
+		}
+
+		snapshot := dataset.name + "@" + name
+
+		if _, ok := backend.datasets[snapshot]; !ok {
+			continue
+		}
+
+		// descendants which don't have incremental source are sent in full
+		var base string
+
+		if result.Base != "" {
+			_, baseName, err := zfs.SplitSnapshotName(result.Base)
+			if err != nil {
+				return stream{}, err
+			}
+
+			if _, ok := backend.datasets[dataset.name+"@"+baseName]; ok {
+				base = dataset.name + "@" + baseName
+			}
+		}
+
+		child, err := backend.getSnapshotStream(snapshot, base, parsed.raw)
+		if err != nil {
+			return stream{}, err
+		}
+
+		child.Path = strings.TrimPrefix(dataset.name, filesystem)
+		child.Properties = backend.getLocalProperties(dataset.name)
+
+		result.Children = append(result.Children, child)
+	}
+
+	return result, nil
+}
+
+func (backend *Backend) getSnapshotStream(
+	name string,
+	base string,
+	raw bool,
+) (stream, error) {
+	snapshot, err := backend.getSnapshot(name)
+	if err != nil {
+		return stream{}, err
+	}
+
+	result := stream{
+		Snapshot:   snapshot.name,
+		GUID:       snapshot.guid,
+		Creation:   snapshot.creation,
+		Referenced: snapshot.referenced,
+		Written:    snapshot.written,
+		Raw:        raw,
+		Volsize:    snapshot.volsize,
+	}
+
+	if root := backend.getEncryptionRoot(snapshot.name); root != nil {
+		switch {
+		case raw:
+			result.Key = root.key
+		case !root.keyLoaded:
+			return stream{}, fmt.Errorf(
+				"cannot send '%s': encryption key is not loaded, "+
+					"use raw send",
+				snapshot.name,
+			)
+		}
+	}
+
+	if base == "" {
+		return result, nil
+	}
+
+	if strings.HasPrefix(base, "@") {
+		base = getParent(snapshot.name) + base
+	}
+
+	baseSnapshot, err := backend.getSnapshot(base)
+	if err != nil {
+		return stream{}, err
+	}
+
+	if baseSnapshot.createtxg >= snapshot.createtxg {
+		return stream{}, fmt.Errorf(
+			"cannot send '%s': incremental source '%s' is not earlier",
+			snapshot.name,
+			base,
+		)
+	}
+
+	result.Base = baseSnapshot.name
+	result.BaseGUID = baseSnapshot.guid
+
+	// written is amount of data since previous snapshot, so stream from
+	// older base includes data of intermediate snapshots as well
+	result.Written = 0
+
+	for _, child := range backend.getChildren(getParent(snapshot.name)) {
+		if child.createtxg > baseSnapshot.createtxg &&
+			child.createtxg <= snapshot.createtxg {
+			result.Written += child.written
+		}
+	}
+
+	return result, nil
+}
+
+func parseSendArgs(args []string) (sendArgs, error) {
+	var parsed sendArgs
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch arg {
+		case `-i`, `-I`, `-t`:
+			if i+1 >= len(args) {
+				return parsed, fmt.Errorf("missing value of %s flag", arg)
+			}
+
+			i++
+
+			if arg == `-t` {
+				parsed.token = args[i]
+			} else {
+				parsed.base = args[i]
+			}
+
+		case `-w`:
+			parsed.raw = true
+
+		case `-R`:
+			parsed.recursive = true
+
+		default:
+			if !strings.HasPrefix(arg, `-`) {
+				parsed.snapshot = arg
+			}
+		}
+	}
+
+	if parsed.snapshot == "" && parsed.token == "" {
+		return parsed, fmt.Errorf("missing snapshot to send")
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,143 @@
+package zfs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// sendFlagsAllowed are flags of zfs send which change only content of
+	// the stream, but not the way how it's received.
+	sendFlagsAllowed = map[rune]string{
+		'L': "large blocks",
+		'e': "embedded data",
+		'p': "properties",
+		'b': "received properties",
+		'h': "holds",
+	}
+
+	// recvPropertiesDenied are properties which can't be overriden on
+	// receive, since they change meaning of the stream.
+	recvPropertiesDenied = map[string]bool{
+		"origin": true,
+	}
+
+	reProperty = regexp.MustCompile(`^[a-z][a-z0-9_:.-]*$`)
+)
+
+// ParseSendFlags parses additional zfs send flags like '-L -e' or '-Le'.
+// Only flags which are known to be safe are allowed.
+func ParseSendFlags(value string) ([]string, error) {
+	flags := []string{}
+
+	for _, field := range strings.Fields(value) {
+		if !strings.HasPrefix(field, "-") || field == "-" ||
+			strings.HasPrefix(field, "--") {
+			return nil, fmt.Errorf("unexpected send flag %q", field)
+		}
+
+		for _, flag := range strings.TrimPrefix(field, "-") {
+			if _, ok := sendFlagsAllowed[flag]; !ok {
+				return nil, fmt.Errorf(
+					"send flag -%c is not allowed, allowed flags are: %s",
+					flag,
+					getAllowedSendFlags(),
+				)
+			}
+
+			flags = append(flags, "-"+string(flag))
+		}
+	}
+
+	return flags, nil
+}
+
+// ParseRecvExcludeProperties parses comma-separated list of properties
+// which should not be received, like 'mountpoint,canmount', into zfs recv
+// flags.
+func ParseRecvExcludeProperties(value string) ([]string, error) {
+	flags := []string{}
+
+	for _, name := range splitList(value) {
+		err := checkRecvProperty(name)
+		if err != nil {
+			return nil, err
+		}
+
+		flags = append(flags, "-x", name)
+	}
+
+	return flags, nil
+}
+
+// ParseRecvSetProperties parses comma-separated list of properties which
+// should be overriden on receive, like 'canmount=off,readonly=on', into zfs
+// recv flags.
+func ParseRecvSetProperties(value string) ([]string, error) {
+	flags := []string{}
+
+	for _, item := range splitList(value) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(
+				"expected property=value, got %q",
+				item,
+			)
+		}
+
+		err := checkRecvProperty(parts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.ContainsAny(parts[1], " \t\n") {
+			return nil, fmt.Errorf(
+				"value of property %q should not contain spaces",
+				parts[0],
+			)
+		}
+
+		flags = append(flags, "-o", item)
+	}
+
+	return flags, nil
+}
+
+func checkRecvProperty(name string) error {
+	if !reProperty.MatchString(name) {
+		return fmt.Errorf("invalid property name %q", name)
+	}
+
+	if recvPropertiesDenied[name] {
+		return fmt.Errorf("property %q can't be overriden on receive", name)
+	}
+
+	return nil
+}
+
+func getAllowedSendFlags() string {
+	flags := []string{}
+
+	for _, flag := range "Lepbh" {
+		flags = append(
+			flags,
+			fmt.Sprintf("-%c (%s)", flag, sendFlagsAllowed[flag]),
+		)
+	}
+
+	return strings.Join(flags, ", ")
+}
+
+func splitList(value string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
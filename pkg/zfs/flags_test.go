@@ -0,0 +1,176 @@
+package zfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSendFlags(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected []string
+		fail     bool
+	}{
+		{value: "", expected: []string{}},
+		{value: "-L", expected: []string{"-L"}},
+		{value: "-L -e", expected: []string{"-L", "-e"}},
+		{value: "-Le", expected: []string{"-L", "-e"}},
+		{value: "  -p\t-b -h ", expected: []string{"-p", "-b", "-h"}},
+		{value: "-R", fail: true},
+		{value: "-I", fail: true},
+		{value: "-i", fail: true},
+		{value: "-w", fail: true},
+		{value: "-D", fail: true},
+		{value: "-Lw", fail: true},
+		{value: "-L -R", fail: true},
+		{value: "-L; rm -rf /", fail: true},
+		{value: "L", fail: true},
+		{value: "-", fail: true},
+		{value: "--replicate", fail: true},
+		{value: "-L z/home@snapshot", fail: true},
+	}
+
+	for _, testcase := range testcases {
+		flags, err := ParseSendFlags(testcase.value)
+		if testcase.fail {
+			if err == nil {
+				t.Errorf(
+					"ParseSendFlags(%q): expected error, got %q",
+					testcase.value,
+					flags,
+				)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseSendFlags(%q): %s", testcase.value, err)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(flags, testcase.expected) {
+			t.Errorf(
+				"ParseSendFlags(%q) = %q, expected %q",
+				testcase.value,
+				flags,
+				testcase.expected,
+			)
+		}
+	}
+}
+
+func TestParseRecvExcludeProperties(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected []string
+		fail     bool
+	}{
+		{value: "", expected: []string{}},
+		{value: "mountpoint", expected: []string{"-x", "mountpoint"}},
+		{
+			value: "mountpoint, canmount,,zeus:backup",
+			expected: []string{
+				"-x", "mountpoint",
+				"-x", "canmount",
+				"-x", "zeus:backup",
+			},
+		},
+		{value: "origin", fail: true},
+		{value: "-F", fail: true},
+		{value: "mountpoint -F", fail: true},
+		{value: "Mountpoint", fail: true},
+		{value: "mountpoint=none", fail: true},
+	}
+
+	for _, testcase := range testcases {
+		flags, err := ParseRecvExcludeProperties(testcase.value)
+		if testcase.fail {
+			if err == nil {
+				t.Errorf(
+					"ParseRecvExcludeProperties(%q): expected error, got %q",
+					testcase.value,
+					flags,
+				)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseRecvExcludeProperties(%q): %s", testcase.value, err)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(flags, testcase.expected) {
+			t.Errorf(
+				"ParseRecvExcludeProperties(%q) = %q, expected %q",
+				testcase.value,
+				flags,
+				testcase.expected,
+			)
+		}
+	}
+}
+
+func TestParseRecvSetProperties(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected []string
+		fail     bool
+	}{
+		{value: "", expected: []string{}},
+		{value: "readonly=on", expected: []string{"-o", "readonly=on"}},
+		{
+			value: "canmount=off, compression=zstd-3",
+			expected: []string{
+				"-o", "canmount=off",
+				"-o", "compression=zstd-3",
+			},
+		},
+		{
+			value:    "zeus:comment=a=b",
+			expected: []string{"-o", "zeus:comment=a=b"},
+		},
+		{value: "origin=z/home@snapshot", fail: true},
+		{value: "readonly=on,origin=z/home@snapshot", fail: true},
+		{value: "-F", fail: true},
+		{value: "-F=on", fail: true},
+		{value: "readonly", fail: true},
+		{value: "readonly=on -F", fail: true},
+		{value: "readonly=on\t-F", fail: true},
+		{value: "read only=on", fail: true},
+	}
+
+	for _, testcase := range testcases {
+		flags, err := ParseRecvSetProperties(testcase.value)
+		if testcase.fail {
+			if err == nil {
+				t.Errorf(
+					"ParseRecvSetProperties(%q): expected error, got %q",
+					testcase.value,
+					flags,
+				)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseRecvSetProperties(%q): %s", testcase.value, err)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(flags, testcase.expected) {
+			t.Errorf(
+				"ParseRecvSetProperties(%q) = %q, expected %q",
+				testcase.value,
+				flags,
+				testcase.expected,
+			)
+		}
+	}
+}
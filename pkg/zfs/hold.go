@@ -4,11 +4,10 @@ import (
 	"strings"
 
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/zeus/pkg/exec"
 )
 
-func Hold(tag string, snapshot string) error {
-	err := exec.Exec(`zfs`, `hold`, tag, snapshot).Run()
+func (host *Host) Hold(tag string, snapshot string) error {
+	err := host.transport.Exec(`zfs`, `hold`, tag, snapshot).Run()
 	if err != nil {
 		return karma.Format(
 			err,
@@ -19,8 +18,8 @@ func Hold(tag string, snapshot string) error {
 	return nil
 }
 
-func Release(tag string, snapshot string) error {
-	err := exec.Exec(`zfs`, `release`, tag, snapshot).Run()
+func (host *Host) Release(tag string, snapshot string) error {
+	err := host.transport.Exec(`zfs`, `release`, tag, snapshot).Run()
 	if err != nil {
 		return karma.Format(
 			err,
@@ -31,8 +30,8 @@ func Release(tag string, snapshot string) error {
 	return nil
 }
 
-func ListHolds(snapshot string) ([]string, error) {
-	stdout, _, err := exec.Exec(`zfs`, `holds`, `-H`, snapshot).Output()
+func (host *Host) ListHolds(snapshot string) ([]string, error) {
+	stdout, _, err := host.transport.Exec(`zfs`, `holds`, `-H`, snapshot).Output()
 	if err != nil {
 		return nil, karma.Format(
 			err,
@@ -62,8 +61,8 @@ func ListHolds(snapshot string) ([]string, error) {
 	return tags, nil
 }
 
-func HasHold(tag string, snapshot string) (bool, error) {
-	holds, err := ListHolds(snapshot)
+func (host *Host) HasHold(tag string, snapshot string) (bool, error) {
+	holds, err := host.ListHolds(snapshot)
 	if err != nil {
 		return false, err
 	}
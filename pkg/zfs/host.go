@@ -0,0 +1,80 @@
+package zfs
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/reconquest/zeus/pkg/exec"
+)
+
+// Host runs zfs and zpool commands on the machine reachable through given
+// transport.
+type Host struct {
+	transport exec.Transport
+
+	// zeus is zeus command on the host which decompresses received stream.
+	zeus string
+
+	json     bool
+	jsonOnce sync.Once
+}
+
+var (
+	Local = NewHost(exec.LocalTransport{})
+)
+
+func NewHost(transport exec.Transport) *Host {
+	return &Host{
+		transport: transport,
+	}
+}
+
+// SetZeusCommand specifies zeus executable on the host, it's used to
+// decompress stream received by remote host.
+func (host *Host) SetZeusCommand(command string) *Host {
+	host.zeus = command
+
+	return host
+}
+
+func (host *Host) getZeusCommand() string {
+	if host.zeus == "" {
+		return `zeusd`
+	}
+
+	return host.zeus
+}
+
+func (host *Host) IsLocal() bool {
+	_, ok := host.transport.(exec.LocalTransport)
+	return ok
+}
+
+func (host *Host) String() string {
+	return host.transport.String()
+}
+
+// isJSONSupported checks that zfs and zpool installed on the host are able
+// to produce json output, which is available since OpenZFS 2.3.
+func (host *Host) isJSONSupported() bool {
+	host.jsonOnce.Do(func() {
+		stdout, _, err := host.transport.Exec(`zfs`, `version`).Output()
+		if err != nil {
+			return
+		}
+
+		matches := regexp.MustCompile(`(?m)^zfs-(\d+)\.(\d+)`).
+			FindStringSubmatch(stdout)
+		if matches == nil {
+			return
+		}
+
+		major, _ := strconv.Atoi(matches[1])
+		minor, _ := strconv.Atoi(matches[2])
+
+		host.json = major > 2 || (major == 2 && minor >= 3)
+	})
+
+	return host.json
+}
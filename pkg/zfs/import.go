@@ -5,11 +5,10 @@ import (
 	"strings"
 
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/zeus/pkg/exec"
 )
 
-func GetImportList() ([]string, error) {
-	stdout, _, err := exec.Exec(`zpool`, `import`).Output()
+func (host *Host) GetImportList() ([]string, error) {
+	stdout, _, err := host.transport.Exec(`zpool`, `import`).Output()
 	if err != nil {
 		return nil, karma.Format(
 			err,
@@ -19,8 +18,8 @@ func GetImportList() ([]string, error) {
 
 	var (
 		pools   = []string{}
-		matches = regexp.MustCompile(`^\s+pool: ([\w :_.-]+)$`).
-			FindAllStringSubmatch(strings.TrimSpace(stdout), -1)
+		matches = regexp.MustCompile(`(?m)^\s*pool: ([\w:_.-]+)\s*$`).
+			FindAllStringSubmatch(stdout, -1)
 	)
 
 	for _, submatches := range matches {
@@ -30,8 +29,8 @@ func GetImportList() ([]string, error) {
 	return pools, nil
 }
 
-func GetImportedPools() ([]string, error) {
-	stdout, _, err := exec.Exec(
+func (host *Host) GetImportedPools() ([]string, error) {
+	stdout, _, err := host.transport.Exec(
 		`zpool`, `list`, `-H`, `-o`, `name`,
 	).Output()
 	if err != nil {
@@ -44,8 +43,8 @@ func GetImportedPools() ([]string, error) {
 	return strings.Split(strings.TrimSpace(stdout), "\n"), nil
 }
 
-func ImportPool(name string) error {
-	err := exec.Exec(`zpool`, `import`, `-N`, name).Run()
+func (host *Host) ImportPool(name string) error {
+	err := host.transport.Exec(`zpool`, `import`, `-N`, name).Run()
 	if err != nil {
 		return karma.Format(
 			err,
@@ -4,11 +4,10 @@ import (
 	"bytes"
 
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/zeus/pkg/exec"
 )
 
-func LoadKey(dataset string, key string) error {
-	execution := exec.Exec(`zfs`, `load-key`, dataset)
+func (host *Host) LoadKey(dataset string, key string) error {
+	execution := host.transport.Exec(`zfs`, `load-key`, dataset)
 
 	execution.SetStdin(bytes.NewBufferString(key))
 
@@ -23,8 +22,8 @@ func LoadKey(dataset string, key string) error {
 	return nil
 }
 
-func UnloadKey(dataset string) error {
-	execution := exec.Exec(`zfs`, `unload-key`, dataset)
+func (host *Host) UnloadKey(dataset string) error {
+	execution := host.transport.Exec(`zfs`, `unload-key`, dataset)
 
 	err := execution.Run()
 	if err != nil {
@@ -0,0 +1,258 @@
+package zfs
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/reconquest/karma-go"
+)
+
+// SourceKind describes where value of the property comes from.
+type SourceKind int
+
+const (
+	// SourceNone is used for read-only properties and unset properties.
+	SourceNone SourceKind = iota
+	SourceLocal
+	SourceInherited
+	SourceReceived
+	SourceDefault
+	SourceTemporary
+)
+
+const (
+	inheritedFromPrefix = "inherited from "
+)
+
+func (kind SourceKind) String() string {
+	switch kind {
+	case SourceLocal:
+		return "local"
+	case SourceInherited:
+		return "inherited"
+	case SourceReceived:
+		return "received"
+	case SourceDefault:
+		return "default"
+	case SourceTemporary:
+		return "temporary"
+	default:
+		return "none"
+	}
+}
+
+// parseSource parses source column of zfs get output, e.g. 'local' or
+// 'inherited from zroot/home'. Name of the dataset which property is
+// inherited from is returned as well.
+func parseSource(source string) (SourceKind, string, error) {
+	if strings.HasPrefix(source, inheritedFromPrefix) {
+		return SourceInherited,
+			strings.TrimPrefix(source, inheritedFromPrefix),
+			nil
+	}
+
+	switch source {
+	case "-", "none":
+		return SourceNone, "", nil
+	case "local":
+		return SourceLocal, "", nil
+	case "received":
+		return SourceReceived, "", nil
+	case "default":
+		return SourceDefault, "", nil
+	case "temporary":
+		return SourceTemporary, "", nil
+	}
+
+	return SourceNone, "", karma.
+		Describe("source", source).
+		Reason("unexpected property source")
+}
+
+// parseSourceJSON parses source object of zfs get -j output.
+func parseSourceJSON(kind string, data string) (SourceKind, string, error) {
+	switch kind {
+	case "INHERITED":
+		return SourceInherited, data, nil
+	case "NONE":
+		return SourceNone, "", nil
+	case "LOCAL":
+		return SourceLocal, "", nil
+	case "RECEIVED":
+		return SourceReceived, "", nil
+	case "DEFAULT":
+		return SourceDefault, "", nil
+	case "TEMPORARY":
+		return SourceTemporary, "", nil
+	}
+
+	return SourceNone, "", karma.
+		Describe("source", kind).
+		Reason("unexpected property source")
+}
+
+// isUnset reports whether property is not set at all, zfs outputs '-' both
+// as value and source for such properties.
+func isUnset(property Property) bool {
+	return property.Value == "-" && property.SourceKind == SourceNone
+}
+
+// parsePropertiesText parses output of zfs get in scripted mode with name,
+// property, value and source columns. Values are allowed to contain tabs,
+// since other columns can't.
+func parsePropertiesText(stdout string) ([]Property, error) {
+	var properties []Property
+
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, karma.
+				Describe("line", line).
+				Reason("unexpected number of fields in property get response")
+		}
+
+		separator := strings.LastIndex(fields[2], "\t")
+		if separator < 0 {
+			return nil, karma.
+				Describe("line", line).
+				Reason("unexpected number of fields in property get response")
+		}
+
+		property := Property{
+			Source: fields[0],
+			Value:  fields[2][:separator],
+		}
+
+		property.Name = fields[1]
+
+		var err error
+
+		property.SourceKind, property.InheritedFrom, err = parseSource(
+			fields[2][separator+1:],
+		)
+		if err != nil {
+			return nil, karma.
+				Describe("line", line).
+				Format(
+					err,
+					"unable to parse property source",
+				)
+		}
+
+		properties = append(properties, property)
+	}
+
+	return properties, nil
+}
+
+// parsePropertiesJSON parses output of zfs get -j and zpool get -j. Since
+// datasets are returned as object, they are sorted by creation transaction
+// and then by name, so snapshots are ordered from oldest to newest.
+func parsePropertiesJSON(stdout string) ([]Property, error) {
+	type (
+		jsonProperty struct {
+			Value  string `json:"value"`
+			Source struct {
+				Type string `json:"type"`
+				Data string `json:"data"`
+			} `json:"source"`
+		}
+
+		jsonDataset struct {
+			Name       string                  `json:"name"`
+			CreateTXG  string                  `json:"createtxg"`
+			Properties map[string]jsonProperty `json:"properties"`
+		}
+	)
+
+	var output struct {
+		Datasets map[string]jsonDataset `json:"datasets"`
+		Pools    map[string]jsonDataset `json:"pools"`
+	}
+
+	err := json.Unmarshal([]byte(stdout), &output)
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to decode json output",
+		)
+	}
+
+	datasets := []jsonDataset{}
+
+	for name, dataset := range output.Datasets {
+		if dataset.Name == "" {
+			dataset.Name = name
+		}
+
+		datasets = append(datasets, dataset)
+	}
+
+	for name, pool := range output.Pools {
+		if pool.Name == "" {
+			pool.Name = name
+		}
+
+		datasets = append(datasets, pool)
+	}
+
+	sort.SliceStable(datasets, func(i, j int) bool {
+		a, _ := strconv.ParseUint(datasets[i].CreateTXG, 10, 64)
+		b, _ := strconv.ParseUint(datasets[j].CreateTXG, 10, 64)
+
+		if a != b {
+			return a < b
+		}
+
+		return datasets[i].Name < datasets[j].Name
+	})
+
+	var properties []Property
+
+	for _, dataset := range datasets {
+		names := []string{}
+
+		for name := range dataset.Properties {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			value := dataset.Properties[name]
+
+			property := Property{
+				Source: dataset.Name,
+				Value:  value.Value,
+			}
+
+			property.Name = name
+
+			property.SourceKind, property.InheritedFrom, err = parseSourceJSON(
+				value.Source.Type,
+				value.Source.Data,
+			)
+			if err != nil {
+				return nil, karma.
+					Describe("dataset", dataset.Name).
+					Describe("property", name).
+					Format(
+						err,
+						"unable to parse property source",
+					)
+			}
+
+			properties = append(properties, property)
+		}
+	}
+
+	return properties, nil
+}
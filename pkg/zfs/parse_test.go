@@ -0,0 +1,269 @@
+package zfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestProperty(
+	source string,
+	name string,
+	value string,
+	kind SourceKind,
+	inheritedFrom string,
+) Property {
+	property := Property{
+		Value:         value,
+		Source:        source,
+		SourceKind:    kind,
+		InheritedFrom: inheritedFrom,
+	}
+
+	property.Name = name
+
+	return property
+}
+
+func readTestdata(t *testing.T, name string) string {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestParseProperties(t *testing.T) {
+	const (
+		home     = "zroot/home"
+		volume   = "zroot/vm"
+		snapshot = "zroot/home@zeus:2026-03-18T12:00:00Z"
+		newer    = "zroot/home@zeus:2026-03-18T13:00:00Z"
+	)
+
+	testcases := []struct {
+		name     string
+		file     string
+		parse    func(string) ([]Property, error)
+		expected []Property
+		fail     bool
+	}{
+		{
+			name:  "text sources",
+			file:  "get-sources.txt",
+			parse: parsePropertiesText,
+			expected: []Property{
+				newTestProperty(home, "zeus:backup", "on", SourceLocal, ""),
+				newTestProperty(
+					home, "zeus:housekeeping", "by-count",
+					SourceInherited, "zroot",
+				),
+				newTestProperty(home, "compression", "lz4", SourceReceived, ""),
+				newTestProperty(home, "atime", "on", SourceDefault, ""),
+				newTestProperty(home, "readonly", "on", SourceTemporary, ""),
+				newTestProperty(home, "used", "1048576", SourceNone, ""),
+				newTestProperty(
+					home, "zeus:backup:rate-limit", "-",
+					SourceNone, "",
+				),
+			},
+		},
+		{
+			name:  "text values with spaces and tabs",
+			file:  "get-values.txt",
+			parse: parsePropertiesText,
+			expected: []Property{
+				newTestProperty(
+					home, "zeus:comment", "daily backup of home",
+					SourceLocal, "",
+				),
+				newTestProperty(
+					home, "zeus:comment:tabbed", "a\tb\tc",
+					SourceLocal, "",
+				),
+				newTestProperty(home, "zeus:empty", "", SourceLocal, ""),
+				newTestProperty(
+					home, "zeus:backup:recv-flags", "-o compression=zstd",
+					SourceInherited, "zroot/data set",
+				),
+			},
+		},
+		{
+			name:  "text dataset types",
+			file:  "get-types.txt",
+			parse: parsePropertiesText,
+			expected: []Property{
+				newTestProperty(home, "type", "filesystem", SourceNone, ""),
+				newTestProperty(snapshot, "type", "snapshot", SourceNone, ""),
+				newTestProperty(
+					snapshot, "zeus::managed", "yes",
+					SourceLocal, "",
+				),
+				newTestProperty(volume, "type", "volume", SourceNone, ""),
+				newTestProperty(
+					volume, "volsize", "10737418240",
+					SourceLocal, "",
+				),
+			},
+		},
+		{
+			name:  "text line without source",
+			file:  "get-malformed-fields.txt",
+			parse: parsePropertiesText,
+			fail:  true,
+		},
+		{
+			name:  "text line with space separated source",
+			file:  "get-malformed-separator.txt",
+			parse: parsePropertiesText,
+			fail:  true,
+		},
+		{
+			name:  "text unknown source",
+			file:  "get-malformed-source.txt",
+			parse: parsePropertiesText,
+			fail:  true,
+		},
+		{
+			name:  "json sources",
+			file:  "get-sources.json",
+			parse: parsePropertiesJSON,
+			expected: []Property{
+				newTestProperty(home, "atime", "on", SourceDefault, ""),
+				newTestProperty(home, "compression", "lz4", SourceReceived, ""),
+				newTestProperty(home, "readonly", "on", SourceTemporary, ""),
+				newTestProperty(home, "used", "1048576", SourceNone, ""),
+				newTestProperty(home, "zeus:backup", "on", SourceLocal, ""),
+				newTestProperty(
+					home, "zeus:backup:rate-limit", "-",
+					SourceNone, "",
+				),
+				newTestProperty(
+					home, "zeus:housekeeping", "by-count",
+					SourceInherited, "zroot",
+				),
+			},
+		},
+		{
+			name:  "json values with spaces and tabs",
+			file:  "get-values.json",
+			parse: parsePropertiesJSON,
+			expected: []Property{
+				newTestProperty(
+					home, "zeus:backup:recv-flags", "-o compression=zstd",
+					SourceInherited, "zroot/data set",
+				),
+				newTestProperty(
+					home, "zeus:comment", "daily backup of home",
+					SourceLocal, "",
+				),
+				newTestProperty(
+					home, "zeus:comment:tabbed", "a\tb\tc",
+					SourceLocal, "",
+				),
+				newTestProperty(home, "zeus:empty", "", SourceLocal, ""),
+			},
+		},
+		{
+			// datasets are ordered by createtxg, so snapshots are listed
+			// from oldest to newest
+			name:  "json dataset types",
+			file:  "get-types.json",
+			parse: parsePropertiesJSON,
+			expected: []Property{
+				newTestProperty(home, "type", "filesystem", SourceNone, ""),
+				newTestProperty(volume, "type", "volume", SourceNone, ""),
+				newTestProperty(
+					volume, "volsize", "10737418240",
+					SourceLocal, "",
+				),
+				newTestProperty(snapshot, "type", "snapshot", SourceNone, ""),
+				newTestProperty(
+					snapshot, "zeus::managed", "yes",
+					SourceLocal, "",
+				),
+				newTestProperty(newer, "type", "snapshot", SourceNone, ""),
+			},
+		},
+		{
+			name:  "json pools",
+			file:  "get-pools.json",
+			parse: parsePropertiesJSON,
+			expected: []Property{
+				newTestProperty(
+					"zbackup", "guid", "9876543210",
+					SourceNone, "",
+				),
+				newTestProperty(
+					"zbackup", "size", "1099511627776",
+					SourceNone, "",
+				),
+			},
+		},
+		{
+			name:  "json truncated output",
+			file:  "get-malformed.json",
+			parse: parsePropertiesJSON,
+			fail:  true,
+		},
+		{
+			name:  "json unknown source",
+			file:  "get-malformed-source.json",
+			parse: parsePropertiesJSON,
+			fail:  true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			properties, err := testcase.parse(
+				readTestdata(t, testcase.file),
+			)
+			if testcase.fail {
+				if err == nil {
+					t.Fatalf("expected error, got %v", properties)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(properties, testcase.expected) {
+				t.Errorf(
+					"unexpected properties\nexpected: %#v\n     got: %#v",
+					testcase.expected,
+					properties,
+				)
+			}
+		})
+	}
+}
+
+func TestIsUnset(t *testing.T) {
+	testcases := []struct {
+		property Property
+		unset    bool
+	}{
+		{newTestProperty("zroot", "zeus:backup", "-", SourceNone, ""), true},
+		{newTestProperty("zroot", "zeus:backup", "-", SourceLocal, ""), false},
+		{newTestProperty("zroot", "zeus:backup", "", SourceNone, ""), false},
+		{newTestProperty("zroot", "used", "0", SourceNone, ""), false},
+	}
+
+	for _, testcase := range testcases {
+		if unset := isUnset(testcase.property); unset != testcase.unset {
+			t.Errorf(
+				"isUnset(%q from %s) = %v, expected %v",
+				testcase.property.Value,
+				testcase.property.SourceKind,
+				unset,
+				testcase.unset,
+			)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"strings"
 
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/zeus/pkg/exec"
 )
 
 type (
@@ -13,6 +12,11 @@ type (
 		PropertyRequest
 		Value  string
 		Source string
+
+		SourceKind SourceKind
+		// InheritedFrom is a name of the dataset which value is inherited
+		// from if SourceKind is SourceInherited.
+		InheritedFrom string
 	}
 
 	PropertyRequest struct {
@@ -22,6 +26,7 @@ type (
 		System     bool
 		Snapshot   bool
 		Filesystem bool
+		Volume     bool
 	}
 
 	PropertyMapping struct {
@@ -30,22 +35,33 @@ type (
 	}
 )
 
-func GetPoolProperties(
+func (host *Host) GetPoolProperties(
 	requests []PropertyRequest,
 	pools ...string,
 ) ([]PropertyMapping, error) {
-	return getProperties(`zpool`, requests, pools...)
+	return host.getProperties(`zpool`, false, requests, pools...)
 }
 
-func GetDatasetProperties(
+func (host *Host) GetDatasetProperties(
 	requests []PropertyRequest,
 	datasets ...string,
 ) ([]PropertyMapping, error) {
-	return getProperties(`zfs`, requests, datasets...)
+	return host.getProperties(`zfs`, false, requests, datasets...)
 }
 
-func SetDatasetProperty(dataset string, name string, value string) error {
-	err := exec.Exec(
+func (host *Host) GetDatasetPropertiesRecursive(
+	requests []PropertyRequest,
+	datasets ...string,
+) ([]PropertyMapping, error) {
+	return host.getProperties(`zfs`, true, requests, datasets...)
+}
+
+func (host *Host) SetDatasetProperty(
+	dataset string,
+	name string,
+	value string,
+) error {
+	err := host.transport.Exec(
 		`zfs`, `set`,
 		fmt.Sprintf("%s=%s", name, value),
 		dataset,
@@ -63,12 +79,18 @@ func SetDatasetProperty(dataset string, name string, value string) error {
 	return nil
 }
 
-func getProperties(
+func (host *Host) getProperties(
 	level string,
+	recursive bool,
 	requests []PropertyRequest,
 	datasets ...string,
 ) ([]PropertyMapping, error) {
-	properties, err := getPropertyList(level, requests, datasets...)
+	properties, err := host.getPropertyList(
+		level,
+		recursive,
+		requests,
+		datasets...,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -95,8 +117,9 @@ func getProperties(
 	return mappings, nil
 }
 
-func getPropertyList(
+func (host *Host) getPropertyList(
 	level string,
+	recursive bool,
 	requests []PropertyRequest,
 	datasets ...string,
 ) ([]Property, error) {
@@ -131,6 +154,7 @@ func getPropertyList(
 
 		set(&types, request.Snapshot, "snapshot")
 		set(&types, request.Filesystem, "filesystem")
+		set(&types, request.Volume, "volume")
 
 		set(&sources, request.Local, "local")
 		set(&sources, request.Inherited, "inherited")
@@ -141,12 +165,18 @@ func getPropertyList(
 		}
 	}
 
-	args := []string{
-		`get`, strings.Join(names, ","),
-		`-H`, `-o`, `name,property,value,source`,
-		`-p`,
+	jsonOutput := host.isJSONSupported()
+
+	args := []string{`get`, strings.Join(names, ",")}
+
+	if jsonOutput {
+		args = append(args, `-j`)
+	} else {
+		args = append(args, `-H`, `-o`, `name,property,value,source`)
 	}
 
+	args = append(args, `-p`)
+
 	if len(types.list) > 0 {
 		args = append(args, `-t`, strings.Join(types.list, ","))
 	}
@@ -155,6 +185,10 @@ func getPropertyList(
 		args = append(args, `-s`, strings.Join(sources.list, ","))
 	}
 
+	if recursive {
+		args = append(args, `-r`)
+	}
+
 	if len(datasets) > 0 {
 		args = append(args, datasets...)
 	}
@@ -172,7 +206,7 @@ func getPropertyList(
 			)
 	}
 
-	command := exec.Exec(level, args...)
+	command := host.transport.Exec(level, args...)
 
 	stdout, _, err := command.Output()
 	if err != nil {
@@ -185,39 +219,30 @@ func getPropertyList(
 			)
 	}
 
-	var properties []Property
+	var parsed []Property
 
-	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
-		if line == "" {
-			break
-		}
+	if jsonOutput {
+		parsed, err = parsePropertiesJSON(stdout)
+	} else {
+		parsed, err = parsePropertiesText(stdout)
+	}
+	if err != nil {
+		return nil, karma.
+			Describe("command", command).
+			Format(
+				err,
+				"unable to parse property get response",
+			)
+	}
 
-		fields := strings.SplitN(line, "\t", 4)
-		if len(fields) != 4 {
-			return nil, karma.
-				Describe("command", command).
-				Describe("line", line).
-				Format(
-					err,
-					"unexpected number of fields in property get response",
-				)
-		}
+	var properties []Property
 
-		// skip unset values
-		if fields[2] == "-" {
+	for _, property := range parsed {
+		if isUnset(property) {
 			continue
 		}
 
-		property := Property{
-			Source: fields[0],
-			Value:  fields[2],
-		}
-
-		property.Name = fields[1]
-
-		if strings.HasPrefix(fields[3], "inherited from ") {
-			property.Inherited = true
-		}
+		property.Inherited = property.SourceKind == SourceInherited
 
 		if property.Inherited && !inherited[property.Name] {
 			continue
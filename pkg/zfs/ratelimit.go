@@ -0,0 +1,215 @@
+package zfs
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/reconquest/zeus/pkg/formatting"
+	"github.com/reconquest/zeus/pkg/log"
+)
+
+type (
+	// RateLimit limits rate of the send stream in bytes per second, zero
+	// rate means no limit.
+	RateLimit struct {
+		Rate    uint64
+		Windows []RateWindow
+	}
+
+	// RateWindow overrides rate limit during given time of day, From and
+	// To are offsets since midnight. Window which ends before it starts
+	// spans midnight.
+	RateWindow struct {
+		From time.Duration
+		To   time.Duration
+		Rate uint64
+	}
+)
+
+// GetRate returns rate limit which is effective at given time.
+func (limit RateLimit) GetRate(now time.Time) uint64 {
+	offset := now.Sub(
+		time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()),
+	)
+
+	for _, window := range limit.Windows {
+		if window.Contains(offset) {
+			return window.Rate
+		}
+	}
+
+	return limit.Rate
+}
+
+// IsUnlimited reports whether rate is never limited.
+func (limit RateLimit) IsUnlimited() bool {
+	if limit.Rate != 0 {
+		return false
+	}
+
+	for _, window := range limit.Windows {
+		if window.Rate != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Contains reports whether given offset since midnight is within window.
+func (window RateWindow) Contains(offset time.Duration) bool {
+	if window.From <= window.To {
+		return offset >= window.From && offset < window.To
+	}
+
+	return offset >= window.From || offset < window.To
+}
+
+// RateLimiter limits total rate of all send streams which are read through
+// it, so concurrent backups don't exceed the limit together.
+type RateLimiter struct {
+	limit RateLimit
+
+	mutex sync.Mutex
+	meter rateMeter
+}
+
+// NewRateLimiter returns limiter which is shared by send streams.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{limit: limit}
+}
+
+// GetRate returns total rate limit which is effective at given time.
+func (limiter *RateLimiter) GetRate(now time.Time) uint64 {
+	if limiter == nil {
+		return 0
+	}
+
+	return limiter.limit.GetRate(now)
+}
+
+// IsUnlimited reports whether rate is never limited.
+func (limiter *RateLimiter) IsUnlimited() bool {
+	return limiter == nil || limiter.limit.IsUnlimited()
+}
+
+// take accounts given amount of data read by any stream and returns how
+// long the stream should wait to keep total rate within the limit.
+func (limiter *RateLimiter) take(now time.Time, size uint64) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	rate := limiter.limit.GetRate(now)
+
+	if limiter.meter.reset(now, rate) {
+		if rate == 0 {
+			log.Infof("{copy} total send rate is not limited")
+		} else {
+			log.Infof(
+				"{copy} total send rate is limited to %s/s",
+				formatting.Size(rate),
+			)
+		}
+	}
+
+	return limiter.meter.take(now, size)
+}
+
+// rateMeter counts amount of data read since the rate has been changed.
+type rateMeter struct {
+	rate  uint64
+	since time.Time
+	read  uint64
+}
+
+// reset starts counting anew if rate has been changed and reports whether
+// it happened.
+func (meter *rateMeter) reset(now time.Time, rate uint64) bool {
+	if rate == meter.rate && !meter.since.IsZero() {
+		return false
+	}
+
+	meter.rate = rate
+	meter.since = now
+	meter.read = 0
+
+	return true
+}
+
+// take accounts given amount of read data and returns how long reading
+// should be paused to keep the rate.
+func (meter *rateMeter) take(now time.Time, size uint64) time.Duration {
+	if meter.rate == 0 {
+		return 0
+	}
+
+	meter.read += size
+
+	expected := time.Duration(
+		float64(meter.read) / float64(meter.rate) * float64(time.Second),
+	)
+
+	return expected - now.Sub(meter.since)
+}
+
+// getChunkSize returns amount of data which can be read at once without
+// making stream bursty.
+func getChunkSize(rate uint64) uint64 {
+	return rate/10 + 1
+}
+
+// rateLimitedReader reads underlying reader not faster than rate limit of
+// the stream itself and total rate limit of all streams effective at the
+// moment.
+type rateLimitedReader struct {
+	reader  io.Reader
+	limit   RateLimit
+	limiter *RateLimiter
+
+	meter rateMeter
+}
+
+func (reader *rateLimitedReader) Read(data []byte) (int, error) {
+	now := time.Now()
+
+	rate := reader.limit.GetRate(now)
+	if reader.meter.reset(now, rate) {
+		if rate == 0 {
+			log.Infof("{copy} send rate is not limited")
+		} else {
+			log.Infof(
+				"{copy} send rate is limited to %s/s",
+				formatting.Size(rate),
+			)
+		}
+	}
+
+	for _, rate := range []uint64{rate, reader.limiter.GetRate(now)} {
+		if rate == 0 {
+			continue
+		}
+
+		if chunk := getChunkSize(rate); uint64(len(data)) > chunk {
+			data = data[:chunk]
+		}
+	}
+
+	size, err := reader.reader.Read(data)
+
+	now = time.Now()
+
+	wait := reader.meter.take(now, uint64(size))
+
+	if !reader.limiter.IsUnlimited() {
+		if shared := reader.limiter.take(now, uint64(size)); shared > wait {
+			wait = shared
+		}
+	}
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return size, err
+}
@@ -0,0 +1,79 @@
+package zfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_IsSharedByStreams(t *testing.T) {
+	var (
+		limiter = NewRateLimiter(RateLimit{Rate: 100})
+		now     = time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+	)
+
+	// two streams read a second worth of data at the same moment, so the
+	// second one waits for another second
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second} {
+		wait := limiter.take(now, 100)
+		if wait != expected {
+			t.Errorf("stream %d: expected wait %s, got %s", i, expected, wait)
+		}
+	}
+
+	wait := limiter.take(now.Add(3*time.Second), 100)
+	if wait != 0 {
+		t.Errorf("expected no wait after limit is caught up, got %s", wait)
+	}
+}
+
+func TestRateLimiter_UsesRateWindows(t *testing.T) {
+	var (
+		limiter = NewRateLimiter(RateLimit{
+			Rate: 100,
+			Windows: []RateWindow{
+				{From: time.Hour, To: 6 * time.Hour, Rate: 0},
+			},
+		})
+		day = time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+	)
+
+	if wait := limiter.take(day, 200); wait != 2*time.Second {
+		t.Errorf("expected wait 2s during the day, got %s", wait)
+	}
+
+	// rate is counted anew when window starts
+	night := time.Date(2026, 3, 19, 2, 0, 0, 0, time.UTC)
+
+	if wait := limiter.take(night, 1000); wait != 0 {
+		t.Errorf("expected no wait at night, got %s", wait)
+	}
+}
+
+func TestGetEffectiveRate(t *testing.T) {
+	now := time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		limit    RateLimit
+		limiter  *RateLimiter
+		expected uint64
+	}{
+		{RateLimit{}, nil, 0},
+		{RateLimit{Rate: 100}, nil, 100},
+		{RateLimit{}, NewRateLimiter(RateLimit{Rate: 200}), 200},
+		{RateLimit{Rate: 100}, NewRateLimiter(RateLimit{Rate: 200}), 100},
+		{RateLimit{Rate: 300}, NewRateLimiter(RateLimit{Rate: 200}), 200},
+	}
+
+	for _, testcase := range testcases {
+		rate := getEffectiveRate(testcase.limit, testcase.limiter, now)
+		if rate != testcase.expected {
+			t.Errorf(
+				"stream limit %d, total limit %d: expected %d, got %d",
+				testcase.limit.Rate,
+				testcase.limiter.GetRate(now),
+				testcase.expected,
+				rate,
+			)
+		}
+	}
+}
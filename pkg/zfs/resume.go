@@ -0,0 +1,44 @@
+package zfs
+
+import (
+	"github.com/reconquest/zeus/pkg/constants"
+)
+
+// GetResumeToken returns token which can be used to resume interrupted
+// receive into given dataset or empty string if there is nothing to resume.
+func (host *Host) GetResumeToken(dataset string) (string, error) {
+	exists, err := host.IsDatasetExists(dataset)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return "", nil
+	}
+
+	mappings, err := host.GetDatasetProperties([]PropertyRequest{
+		{
+			Name:       constants.ReceiveResumeToken,
+			System:     true,
+			Filesystem: true,
+			Volume:     true,
+		},
+	}, dataset)
+	if err != nil {
+		return "", err
+	}
+
+	for _, mapping := range mappings {
+		if mapping.Source != dataset {
+			continue
+		}
+
+		for _, property := range mapping.Properties {
+			if property.Name == constants.ReceiveResumeToken {
+				return property.Value, nil
+			}
+		}
+	}
+
+	return "", nil
+}
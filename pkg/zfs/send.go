@@ -0,0 +1,190 @@
+package zfs
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/reconquest/callbackwriter-go"
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/lexec-go"
+	"github.com/reconquest/lineflushwriter-go"
+	"github.com/reconquest/nopio-go"
+)
+
+type sendStream struct {
+	io.ReadCloser
+
+	execution *lexec.Execution
+	stderr    *sendStderr
+}
+
+func (stream sendStream) Close() error {
+	// closing pipe makes zfs send exit if stream has not been read
+	// completely, e.g. when zfs recv has failed
+	stream.ReadCloser.Close()
+
+	err := stream.execution.Wait()
+
+	stream.stderr.writer.Close()
+
+	if err != nil {
+		return karma.
+			Describe("stderr", stream.stderr.String()).
+			Format(
+				err,
+				"zfs send failed",
+			)
+	}
+
+	return nil
+}
+
+// sendStderr passes progress lines written by zfs send into stderr to the
+// progress function and keeps other lines, so they are reported if zfs send
+// fails.
+type sendStderr struct {
+	writer   io.WriteCloser
+	progress func(SendProgress)
+
+	mutex sync.Mutex
+	lines []string
+}
+
+func newSendStderr(progress func(SendProgress)) *sendStderr {
+	stderr := &sendStderr{
+		progress: progress,
+	}
+
+	stderr.writer = lineflushwriter.New(
+		callbackwriter.New(
+			nopio.NopWriteCloser{},
+			stderr.handle,
+			nil,
+		),
+		&sync.Mutex{},
+		true,
+	)
+
+	return stderr
+}
+
+func (stderr *sendStderr) handle(line []byte) {
+	progress, ok := parseSendProgress(string(line))
+	if ok {
+		if stderr.progress != nil {
+			stderr.progress(progress)
+		}
+
+		return
+	}
+
+	stderr.mutex.Lock()
+	defer stderr.mutex.Unlock()
+
+	stderr.lines = append(
+		stderr.lines,
+		strings.TrimSuffix(string(line), "\n"),
+	)
+}
+
+func (stderr *sendStderr) String() string {
+	stderr.mutex.Lock()
+	defer stderr.mutex.Unlock()
+
+	return strings.Join(stderr.lines, "\n")
+}
+
+func (host *Host) Send(
+	progress func(SendProgress),
+	args ...string,
+) (io.ReadCloser, error) {
+	execution := host.transport.Exec(
+		`zfs`, append([]string{`send`}, args...)...,
+	)
+
+	if execution.IsPlanned() {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	stderr := newSendStderr(progress)
+
+	send := execution.SetStderr(stderr.writer).NoStdLog()
+
+	stdout, err := send.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = send.Start()
+	if err != nil {
+		return nil, karma.Format(
+			err,
+			"unable to run zfs send",
+		)
+	}
+
+	return sendStream{
+		ReadCloser: stdout,
+		execution:  send,
+		stderr:     stderr,
+	}, nil
+}
+
+func (host *Host) Receive(stream io.Reader, args ...string) error {
+	recv := host.transport.Exec(`zfs`, append([]string{`recv`}, args...)...)
+
+	recv.SetStdin(stream)
+
+	err := recv.Run()
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to run zfs recv",
+		)
+	}
+
+	return nil
+}
+
+// ReceiveCompressed is the same as Receive, but stream is compressed with
+// given compression. Remote host decompresses stream by running zeus in
+// receive mode, which passes decompressed stream to zfs recv, so zeus
+// should be installed on remote host.
+func (host *Host) ReceiveCompressed(
+	stream io.Reader,
+	compression Compression,
+	args ...string,
+) error {
+	if host.IsLocal() {
+		decompressed, err := Decompress(stream, compression)
+		if err != nil {
+			return err
+		}
+
+		defer decompressed.Close()
+
+		return host.Receive(decompressed, args...)
+	}
+
+	recv := host.transport.Exec(
+		host.getZeusCommand(),
+		append(
+			[]string{`receive`, `--compression=` + string(compression), `--`},
+			args...,
+		)...,
+	)
+
+	recv.SetStdin(stream)
+
+	err := recv.Run()
+	if err != nil {
+		return karma.Format(
+			err,
+			"unable to run zfs recv with %s decompression",
+			compression,
+		)
+	}
+
+	return nil
+}
@@ -4,11 +4,10 @@ import (
 	"strings"
 
 	"github.com/reconquest/karma-go"
-	"github.com/reconquest/zeus/pkg/exec"
 )
 
-func CreateSnapshot(snapshot string) error {
-	err := exec.Exec(`zfs`, `snapshot`, snapshot).Run()
+func (host *Host) CreateSnapshot(snapshot string) error {
+	err := host.transport.Exec(`zfs`, `snapshot`, snapshot).Run()
 	if err != nil {
 		return karma.
 			Describe("snapshot", snapshot).
@@ -21,8 +20,24 @@ func CreateSnapshot(snapshot string) error {
 	return nil
 }
 
-func ListSnapshots(dataset string) ([]string, error) {
-	stdout, _, err := exec.Exec(
+// CreateSnapshotRecursive atomically creates snapshots with the same name of
+// given filesystem and all its descendants.
+func (host *Host) CreateSnapshotRecursive(snapshot string) error {
+	err := host.transport.Exec(`zfs`, `snapshot`, `-r`, snapshot).Run()
+	if err != nil {
+		return karma.
+			Describe("snapshot", snapshot).
+			Format(
+				err,
+				"unable to create recursive dataset snapshot",
+			)
+	}
+
+	return nil
+}
+
+func (host *Host) ListSnapshots(dataset string) ([]string, error) {
+	stdout, _, err := host.transport.Exec(
 		`zfs`, `list`, `-t`, `snap`, `-Hro`, `name`, dataset,
 	).Output()
 	if err != nil {
@@ -44,6 +59,30 @@ func ListSnapshots(dataset string) ([]string, error) {
 	}
 }
 
+// ListSnapshotTree returns given snapshot and snapshots with the same name of
+// all descendants of its filesystem, parent snapshots go first.
+func ListSnapshotTree(backend Backend, snapshot string) ([]string, error) {
+	filesystem, name, err := SplitSnapshotName(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := backend.ListSnapshots(filesystem)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := []string{}
+
+	for _, candidate := range snapshots {
+		if strings.HasSuffix(candidate, "@"+name) {
+			tree = append(tree, candidate)
+		}
+	}
+
+	return tree, nil
+}
+
 func SplitSnapshotName(name string) (string, string, error) {
 	parts := strings.SplitN(name, "@", 2)
 	if len(parts) != 2 {
@@ -0,0 +1,136 @@
+package zfs
+
+import (
+	"strconv"
+
+	"github.com/reconquest/karma-go"
+	"github.com/reconquest/zeus/pkg/constants"
+)
+
+// GetAvailableSpace returns amount of space available for given dataset,
+// it takes quotas, reservations and pool slop space into account unlike
+// free space of the pool.
+func GetAvailableSpace(backend Backend, dataset string) (uint64, error) {
+	mappings, err := backend.GetDatasetProperties([]PropertyRequest{
+		{Name: constants.Available, System: true},
+	}, dataset)
+	if err != nil {
+		return 0, karma.Format(
+			err,
+			"unable to get available space of %q",
+			dataset,
+		)
+	}
+
+	for _, mapping := range mappings {
+		if mapping.Source != dataset {
+			continue
+		}
+
+		for _, property := range mapping.Properties {
+			if property.Name != constants.Available {
+				continue
+			}
+
+			available, err := strconv.ParseUint(property.Value, 10, 64)
+			if err != nil {
+				return 0, karma.
+					Describe("value", property.Value).
+					Format(
+						err,
+						"unable to parse available space",
+					)
+			}
+
+			return available, nil
+		}
+	}
+
+	return 0, karma.
+		Describe("dataset", dataset).
+		Reason("available space is not reported")
+}
+
+// EstimateNewSnapshot returns expected size of the send stream of snapshot
+// of given dataset which is not made yet. Size of incremental stream is
+// amount of data written since given base snapshot and size of full stream
+// is amount of data referenced by dataset. Descendants which will be sent
+// in replication stream are taken into account if recursive is set, ones
+// which don't have base snapshot are sent in full.
+func EstimateNewSnapshot(
+	backend Backend,
+	dataset string,
+	base string,
+	recursive bool,
+) (uint64, error) {
+	requests := []PropertyRequest{
+		{
+			Name:       constants.Referenced,
+			System:     true,
+			Filesystem: true,
+			Volume:     true,
+		},
+	}
+
+	written := constants.Written + "@" + base
+
+	if base != "" {
+		requests = append(requests, PropertyRequest{
+			Name:       written,
+			System:     true,
+			Filesystem: true,
+			Volume:     true,
+		})
+	}
+
+	var (
+		mappings []PropertyMapping
+		err      error
+	)
+
+	if recursive {
+		mappings, err = backend.GetDatasetPropertiesRecursive(
+			requests,
+			dataset,
+		)
+	} else {
+		mappings, err = backend.GetDatasetProperties(requests, dataset)
+	}
+	if err != nil {
+		return 0, karma.Format(
+			err,
+			"unable to get amount of data to send from %q",
+			dataset,
+		)
+	}
+
+	var size uint64
+
+	for _, mapping := range mappings {
+		values := map[string]uint64{}
+
+		for _, property := range mapping.Properties {
+			value, err := strconv.ParseUint(property.Value, 10, 64)
+			if err != nil {
+				return 0, karma.
+					Describe("dataset", mapping.Source).
+					Describe("property", property.Name).
+					Describe("value", property.Value).
+					Format(
+						err,
+						"unable to parse amount of data",
+					)
+			}
+
+			values[property.Name] = value
+		}
+
+		if value, ok := values[written]; ok {
+			size += value
+		} else {
+			size += values[constants.Referenced]
+		}
+	}
+
+	return size, nil
+}